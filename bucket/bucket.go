@@ -3,7 +3,9 @@ package bucket
 import (
 	"context"
 	"fmt"
+	"iter"
 	"strings"
+	"time"
 
 	"github.com/nats-io/nats.go/jetstream"
 
@@ -11,16 +13,28 @@ import (
 	"github.com/mikluko/peanats/codec"
 )
 
+var (
+	// ErrKeyExists is returned by Create when the key already holds a value.
+	ErrKeyExists = jetstream.ErrKeyExists
+	// ErrKeyNotFound is returned when the key does not exist or was deleted.
+	ErrKeyNotFound = jetstream.ErrKeyNotFound
+)
+
 type Bucket[T any] interface {
 	Get(ctx context.Context, key string) (Entry[T], error)
 	GetRevision(ctx context.Context, key string, rev uint64) (Entry[T], error)
 	GetLatestRevision(ctx context.Context, key string) (Entry[T], error)
 	Put(ctx context.Context, entry PutEntry[T]) (uint64, error)
 	Update(ctx context.Context, entry UpdateEntry[T]) (uint64, error)
+	Create(ctx context.Context, entry PutEntry[T], opts ...CreateOption) (uint64, error)
 	Delete(ctx context.Context, key string, opts ...DeleteOption) error
+	Purge(ctx context.Context, key string, opts ...DeleteOption) error
+	PurgeDeletes(ctx context.Context, opts ...PurgeOption) error
 	History(ctx context.Context, key string, opts ...HistoryOption) ([]Entry[T], error)
 	Watch(ctx context.Context, match string, opts ...WatcherOption) (Watcher[T], error)
 	WatchAll(ctx context.Context, opts ...WatcherOption) (Watcher[T], error)
+	Keys(ctx context.Context, filter string) iter.Seq2[string, error]
+	Entries(ctx context.Context, filter string, opts ...ListOption) iter.Seq2[Entry[T], error]
 }
 
 func NewBucket[T any](bucket jetstream.KeyValue, opts ...BucketOption) Bucket[T] {
@@ -145,6 +159,28 @@ func (s *bucketImpl[T]) Update(ctx context.Context, entry UpdateEntry[T]) (uint6
 	return s.bucket.Update(ctx, s.prefixed(entry.Key()), b, entry.Revision())
 }
 
+type CreateOption = jetstream.KVCreateOpt
+
+// CreateTTL makes the server remove the key once ttl has passed. The bucket
+// must be created with LimitMarkerTTL set.
+func CreateTTL(ttl time.Duration) CreateOption {
+	return jetstream.KeyTTL(ttl)
+}
+
+// Create puts the entry only if the key does not exist or was deleted, and
+// fails with ErrKeyExists otherwise.
+func (s *bucketImpl[T]) Create(ctx context.Context, entry PutEntry[T], opts ...CreateOption) (uint64, error) {
+	h := entry.Header()
+	if s.contentEncoding != 0 {
+		codec.SetContentEncoding(h, s.contentEncoding)
+	}
+	b, err := encodeBucketEntryHeader(h, entry.Value())
+	if err != nil {
+		return 0, err
+	}
+	return s.bucket.Create(ctx, s.prefixed(entry.Key()), b, opts...)
+}
+
 type bucketImplUpdateParams struct {
 	header peanats.Header
 }
@@ -159,10 +195,30 @@ func UpdateWithHeader(header peanats.Header) UpdateOption {
 
 type DeleteOption = jetstream.KVDeleteOpt
 
+// PurgeTTL makes the server remove the marker left by Purge once ttl has
+// passed. The bucket must be created with LimitMarkerTTL set.
+func PurgeTTL(ttl time.Duration) DeleteOption {
+	return jetstream.PurgeTTL(ttl)
+}
+
 func (s *bucketImpl[T]) Delete(ctx context.Context, key string, opts ...DeleteOption) error {
 	return s.bucket.Delete(ctx, s.prefixed(key), opts...)
 }
 
+// Purge deletes the key together with all its previous revisions.
+func (s *bucketImpl[T]) Purge(ctx context.Context, key string, opts ...DeleteOption) error {
+	return s.bucket.Purge(ctx, s.prefixed(key), opts...)
+}
+
+type PurgeOption = jetstream.KVPurgeOpt
+
+// PurgeDeletes removes delete markers from the underlying key-value store.
+// The key prefix is not taken into account: markers of the whole store are
+// removed.
+func (s *bucketImpl[T]) PurgeDeletes(ctx context.Context, opts ...PurgeOption) error {
+	return s.bucket.PurgeDeletes(ctx, opts...)
+}
+
 type HistoryOption = jetstream.WatchOpt
 
 func (s *bucketImpl[T]) History(ctx context.Context, key string, opts ...HistoryOption) ([]Entry[T], error) {
@@ -196,6 +196,24 @@ func TestBucket_Put(t *testing.T) {
 	assert.Equal(t, uint64(1), rev)
 }
 
+func TestBucket_Create(t *testing.T) {
+	const expect = "----\r\nContent-Type: application/json\r\nX-Breed: shavka\r\n\r\n" + `{"name":"balooney"}`
+	e := testPutUpdateEntryImpl{
+		key: "had.a.dog",
+		hdr: peanats.Header{"X-Breed": []string{"shavka"}},
+		mod: &testModel{Name: "balooney"},
+	}
+
+	nb := jetstreammock.NewKeyValue(t)
+	nb.EXPECT().Create(mock.Anything, "parson.had.a.dog", []byte(expect)).Return(uint64(1), nil)
+
+	b := bucket.NewBucket[testModel](nb, bucket.BucketKeyPrefix("parson"))
+	rev, err := b.Create(t.Context(), &e)
+
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), rev)
+}
+
 func TestBucket_Update(t *testing.T) {
 	e := testPutUpdateEntryImpl{
 		key: "parson.had.a.dog",
@@ -0,0 +1,213 @@
+package bucket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/mikluko/peanats"
+)
+
+// Cache is an in-memory copy of a bucket kept in sync by a watcher. Reads are
+// served from memory and never block on the watcher.
+type Cache[T any] interface {
+	// Get returns the cached entry of key.
+	Get(key string) (Entry[T], bool)
+	// Range calls yield for every cached entry until it returns false. It can
+	// be used as an iter.Seq.
+	Range(yield func(Entry[T]) bool)
+	// Revision returns the revision of the last applied update.
+	Revision() uint64
+	// Ready is closed once the initial values are loaded.
+	Ready() <-chan struct{}
+	// Subscribe registers h to be called for every applied update, deletes
+	// included. Handlers are called in order by the watcher, so they must not
+	// block. The returned function unregisters h.
+	Subscribe(h EntryHandler[T]) (unsubscribe func())
+	// Stop stops the watcher and waits for it to exit. The cached entries stay
+	// readable.
+	Stop()
+}
+
+// CacheErrorHandler is called with errors the cache recovers from: failed
+// watches, undecodable entries and subscriber errors.
+type CacheErrorHandler func(error)
+
+type CacheOption func(*cacheParams)
+
+type cacheParams struct {
+	delay   peanats.DelayPolicy
+	onError CacheErrorHandler
+}
+
+// CacheBackoff sets the pause before the watcher is restarted. By default the
+// pause grows exponentially from 100ms up to 5s, with jitter.
+func CacheBackoff(policy peanats.DelayPolicy) CacheOption {
+	return func(p *cacheParams) {
+		p.delay = policy
+	}
+}
+
+// CacheOnError sets the handler of recovered errors. By default they are
+// dropped.
+func CacheOnError(h CacheErrorHandler) CacheOption {
+	return func(p *cacheParams) {
+		p.onError = h
+	}
+}
+
+// NewCache starts filling a cache from all the keys of b. The cache follows
+// the bucket until ctx is done or Stop is called. When the watcher goes away,
+// e.g. on a disconnect, it is restarted from the last applied revision.
+func NewCache[T any](ctx context.Context, b Bucket[T], opts ...CacheOption) Cache[T] {
+	p := cacheParams{
+		delay:   jitterDelay{base: 100 * time.Millisecond, max: 5 * time.Second},
+		onError: func(error) {},
+	}
+	for _, o := range opts {
+		o(&p)
+	}
+	c := &cacheImpl[T]{
+		bucket: b,
+		params: p,
+		ready:  make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	ctx, c.cancel = context.WithCancel(ctx)
+	go c.run(ctx)
+	return c
+}
+
+type cacheImpl[T any] struct {
+	bucket  Bucket[T]
+	params  cacheParams
+	entries sync.Map
+	rev     atomic.Uint64
+	ready   chan struct{}
+	once    sync.Once
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	mu   sync.Mutex
+	subs map[*EntryHandler[T]]struct{}
+}
+
+func (c *cacheImpl[T]) Get(key string) (Entry[T], bool) {
+	v, ok := c.entries.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(Entry[T]), true
+}
+
+func (c *cacheImpl[T]) Range(yield func(Entry[T]) bool) {
+	c.entries.Range(func(_, v any) bool {
+		return yield(v.(Entry[T]))
+	})
+}
+
+func (c *cacheImpl[T]) Revision() uint64 {
+	return c.rev.Load()
+}
+
+func (c *cacheImpl[T]) Ready() <-chan struct{} {
+	return c.ready
+}
+
+func (c *cacheImpl[T]) Subscribe(h EntryHandler[T]) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subs == nil {
+		c.subs = make(map[*EntryHandler[T]]struct{})
+	}
+	key := &h
+	c.subs[key] = struct{}{}
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.subs, key)
+	}
+}
+
+func (c *cacheImpl[T]) Stop() {
+	c.cancel()
+	<-c.done
+}
+
+func (c *cacheImpl[T]) run(ctx context.Context) {
+	defer close(c.done)
+	var attempt uint64
+	for {
+		progress, err := c.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		c.params.onError(err)
+		if progress {
+			attempt = 0
+		}
+		attempt++
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.params.delay.Delay(attempt)):
+		}
+	}
+}
+
+// watch applies updates until the watcher goes away. The first watch loads
+// the latest values; later ones replay every revision since the last applied
+// one, so that no update or delete is missed.
+func (c *cacheImpl[T]) watch(ctx context.Context) (progress bool, _ error) {
+	var opts []WatcherOption
+	if rev := c.rev.Load(); rev > 0 {
+		opts = append(opts, WatcherIncludeHistory(), WatcherResumeFromRevision(rev+1))
+	}
+	w, err := c.bucket.WatchAll(ctx, opts...)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = w.Stop() }()
+	for {
+		e, err := w.Next()
+		switch {
+		case errors.Is(err, ErrInitialValuesOver):
+			c.once.Do(func() { close(c.ready) })
+			continue
+		case err != nil && !errors.Is(err, ErrDone):
+			c.params.onError(err)
+			continue
+		case err != nil:
+			return progress, err
+		}
+		c.apply(ctx, e)
+		progress = true
+	}
+}
+
+func (c *cacheImpl[T]) apply(ctx context.Context, e Entry[T]) {
+	if e.Revision() <= c.rev.Load() {
+		return
+	}
+	if e.Operation() == jetstream.KeyValuePut {
+		c.entries.Store(e.Key(), e)
+	} else {
+		c.entries.Delete(e.Key())
+	}
+	c.rev.Store(e.Revision())
+	c.mu.Lock()
+	subs := make([]EntryHandler[T], 0, len(c.subs))
+	for h := range c.subs {
+		subs = append(subs, *h)
+	}
+	c.mu.Unlock()
+	for _, h := range subs {
+		if err := h.HandleEntry(ctx, e); err != nil {
+			c.params.onError(err)
+		}
+	}
+}
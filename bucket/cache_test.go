@@ -0,0 +1,137 @@
+package bucket_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/bucket"
+)
+
+func TestCache(t *testing.T) {
+	kv := setupKeyValue(t)
+	b := bucket.NewBucket[counter](kv, bucket.BucketKeyPrefix("app"))
+	for i, key := range []string{"a", "b", "gone"} {
+		_, err := b.Put(t.Context(), &putEntry{key: key, hdr: peanats.Header{}, val: &counter{N: i}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, b.Delete(t.Context(), "gone"))
+
+	c := bucket.NewCache[counter](t.Context(), b)
+	t.Cleanup(c.Stop)
+	select {
+	case <-c.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("cache not ready")
+	}
+
+	values := func() map[string]int {
+		res := map[string]int{}
+		for e := range c.Range {
+			res[e.Key()] = e.Value().N
+		}
+		return res
+	}
+	assert.Equal(t, map[string]int{"a": 0, "b": 1}, values())
+	_, ok := c.Get("gone")
+	assert.False(t, ok)
+
+	var (
+		mu      sync.Mutex
+		changes []string
+	)
+	unsubscribe := c.Subscribe(bucket.EntryHandlerFunc[counter](func(_ context.Context, e bucket.Entry[counter]) error {
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, e.Key())
+		return nil
+	}))
+
+	rev, err := b.Put(t.Context(), &putEntry{key: "c", hdr: peanats.Header{}, val: &counter{N: 2}})
+	require.NoError(t, err)
+	require.NoError(t, b.Delete(t.Context(), "a"))
+
+	require.Eventually(t, func() bool { return c.Revision() > rev }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, map[string]int{"b": 1, "c": 2}, values())
+	e, ok := c.Get("c")
+	require.True(t, ok)
+	assert.Equal(t, rev, e.Revision())
+	mu.Lock()
+	assert.Equal(t, []string{"c", "a"}, changes)
+	mu.Unlock()
+
+	unsubscribe()
+	rev, err = b.Put(t.Context(), &putEntry{key: "d", hdr: peanats.Header{}, val: &counter{N: 3}})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return c.Revision() == rev }, 5*time.Second, 10*time.Millisecond)
+	mu.Lock()
+	assert.Len(t, changes, 2)
+	mu.Unlock()
+}
+
+// droppingBucket hands out watchers that go away after a few entries, as if
+// the connection was lost.
+type droppingBucket struct {
+	bucket.Bucket[counter]
+	watches atomic.Int32
+}
+
+func (b *droppingBucket) WatchAll(ctx context.Context, opts ...bucket.WatcherOption) (bucket.Watcher[counter], error) {
+	w, err := b.Bucket.WatchAll(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	b.watches.Add(1)
+	return &droppingWatcher{Watcher: w, left: 2}, nil
+}
+
+type droppingWatcher struct {
+	bucket.Watcher[counter]
+	left int
+}
+
+func (w *droppingWatcher) Next() (bucket.Entry[counter], error) {
+	if w.left == 0 {
+		return nil, bucket.ErrDone
+	}
+	e, err := w.Watcher.Next()
+	if err == nil {
+		w.left--
+	}
+	return e, err
+}
+
+func TestCache_Resume(t *testing.T) {
+	kv := setupKeyValue(t)
+	b := &droppingBucket{Bucket: bucket.NewBucket[counter](kv)}
+	for i := range 5 {
+		_, err := b.Put(t.Context(), &putEntry{key: string(rune('a' + i)), hdr: peanats.Header{}, val: &counter{N: i}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, b.Delete(t.Context(), "b"))
+
+	c := bucket.NewCache[counter](t.Context(), b, bucket.CacheBackoff(constantDelay{}))
+	t.Cleanup(c.Stop)
+
+	_, err := b.Put(t.Context(), &putEntry{key: "f", hdr: peanats.Header{}, val: &counter{N: 5}})
+	require.NoError(t, err)
+	require.NoError(t, b.Delete(t.Context(), "a"))
+
+	require.Eventually(t, func() bool {
+		_, ok := c.Get("f")
+		_, gone := c.Get("a")
+		return ok && !gone
+	}, 5*time.Second, 10*time.Millisecond)
+	keys := map[string]int{}
+	for e := range c.Range {
+		keys[e.Key()] = e.Value().N
+	}
+	assert.Equal(t, map[string]int{"c": 2, "d": 3, "e": 4, "f": 5}, keys)
+	assert.Greater(t, b.watches.Load(), int32(1))
+}
@@ -0,0 +1,134 @@
+package bucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+
+	"github.com/mikluko/peanats"
+)
+
+// Number is the value type of a Counter.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
+		~float32 | ~float64
+}
+
+// Counter keeps numbers in a bucket and updates them atomically, since the
+// key-value store has no increment of its own.
+type Counter[N Number] interface {
+	// Add adds delta to the counter at key, retrying on concurrent updates.
+	// A missing counter starts from zero.
+	Add(ctx context.Context, key string, delta N) error
+	// Get returns the value of the counter at key, zero if it is missing.
+	Get(ctx context.Context, key string) (N, error)
+	// Reset sets the counter at key back to zero. The shards of a sharded
+	// counter are reset one by one, so Reset is not atomic: adds racing with
+	// it may survive, and on error the shards named in it keep their values.
+	Reset(ctx context.Context, key string) error
+}
+
+type CounterOption func(*counterParams)
+
+type counterParams struct {
+	shards int
+	mutate []MutateOption
+}
+
+// CounterShards spreads every counter across n sub-keys named
+// <key>._shard.<n>, which keeps them apart from a plain counter at <key>.<n>.
+// Add updates a random shard, which reduces conflicts between concurrent
+// writers, while Get reads and sums all of them. The number of shards of
+// existing counters must not change.
+func CounterShards(n int) CounterOption {
+	return func(p *counterParams) {
+		p.shards = max(n, 1)
+	}
+}
+
+// CounterAttempts sets how many times Add tries to update a counter before
+// giving up with ErrConflict. See MutateAttempts.
+func CounterAttempts(n uint64) CounterOption {
+	return func(p *counterParams) {
+		p.mutate = append(p.mutate, MutateAttempts(n))
+	}
+}
+
+// CounterBackoff sets the pause between attempts of Add. See MutateBackoff.
+func CounterBackoff(policy peanats.DelayPolicy) CounterOption {
+	return func(p *counterParams) {
+		p.mutate = append(p.mutate, MutateBackoff(policy))
+	}
+}
+
+// NewCounter creates a counter keeping its values in b.
+func NewCounter[N Number](b Bucket[N], opts ...CounterOption) Counter[N] {
+	p := counterParams{shards: 1}
+	for _, o := range opts {
+		o(&p)
+	}
+	return &counterImpl[N]{
+		bucket: b,
+		shards: p.shards,
+		mutate: append(p.mutate, MutateCreate()),
+	}
+}
+
+type counterImpl[N Number] struct {
+	bucket Bucket[N]
+	shards int
+	mutate []MutateOption
+}
+
+// counterShardToken separates the shard number from the counter key.
+const counterShardToken = "._shard."
+
+func (c *counterImpl[N]) keys(key string) []string {
+	if c.shards == 1 {
+		return []string{key}
+	}
+	keys := make([]string, c.shards)
+	for i := range keys {
+		keys[i] = key + counterShardToken + strconv.Itoa(i)
+	}
+	return keys
+}
+
+func (c *counterImpl[N]) Add(ctx context.Context, key string, delta N) error {
+	if c.shards > 1 {
+		key = key + counterShardToken + strconv.Itoa(rand.IntN(c.shards))
+	}
+	_, err := Mutate(ctx, c.bucket, key, func(v *N) error {
+		*v += delta
+		return nil
+	}, c.mutate...)
+	return err
+}
+
+func (c *counterImpl[N]) Get(ctx context.Context, key string) (N, error) {
+	var sum N
+	for _, k := range c.keys(key) {
+		ent, err := c.bucket.Get(ctx, k)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		sum += *ent.Value()
+	}
+	return sum, nil
+}
+
+func (c *counterImpl[N]) Reset(ctx context.Context, key string) error {
+	var errs []error
+	for _, k := range c.keys(key) {
+		if err := c.bucket.Delete(ctx, k); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", k, err))
+		}
+	}
+	return errors.Join(errs...)
+}
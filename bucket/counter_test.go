@@ -0,0 +1,81 @@
+package bucket_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats/bucket"
+)
+
+func TestCounter(t *testing.T) {
+	kv := setupKeyValue(t)
+
+	t.Run("concurrent", func(t *testing.T) {
+		for name, opts := range map[string][]bucket.CounterOption{
+			"single":  nil,
+			"sharded": {bucket.CounterShards(4)},
+		} {
+			t.Run(name, func(t *testing.T) {
+				c := bucket.NewCounter(bucket.NewBucket[int64](kv, bucket.BucketKeyPrefix(name)),
+					append(opts, bucket.CounterAttempts(100), bucket.CounterBackoff(constantDelay{}))...)
+				var wg sync.WaitGroup
+				for range 8 {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for range 5 {
+							assert.NoError(t, c.Add(t.Context(), "hits", 2))
+						}
+					}()
+				}
+				wg.Wait()
+				v, err := c.Get(t.Context(), "hits")
+				require.NoError(t, err)
+				assert.Equal(t, int64(80), v)
+
+				require.NoError(t, c.Reset(t.Context(), "hits"))
+				v, err = c.Get(t.Context(), "hits")
+				require.NoError(t, err)
+				assert.Zero(t, v)
+				require.NoError(t, c.Add(t.Context(), "hits", -3))
+				v, err = c.Get(t.Context(), "hits")
+				require.NoError(t, err)
+				assert.Equal(t, int64(-3), v)
+			})
+		}
+	})
+	t.Run("float", func(t *testing.T) {
+		c := bucket.NewCounter(bucket.NewBucket[float64](kv, bucket.BucketKeyPrefix("float")), bucket.CounterShards(2))
+		v, err := c.Get(t.Context(), "total")
+		require.NoError(t, err)
+		assert.Zero(t, v)
+		for _, d := range []float64{0.5, 1.25, -0.25} {
+			require.NoError(t, c.Add(t.Context(), "total", d))
+		}
+		v, err = c.Get(t.Context(), "total")
+		require.NoError(t, err)
+		assert.InDelta(t, 1.5, v, 1e-9)
+	})
+	t.Run("shards apart from plain counters", func(t *testing.T) {
+		b := bucket.NewBucket[int64](kv, bucket.BucketKeyPrefix("apart"))
+		sharded := bucket.NewCounter(b, bucket.CounterShards(2))
+		plain := bucket.NewCounter(b)
+		for range 4 {
+			require.NoError(t, sharded.Add(t.Context(), "hits", 1))
+		}
+		for _, k := range []string{"hits.0", "hits.1"} {
+			require.NoError(t, plain.Add(t.Context(), k, 10))
+		}
+		v, err := sharded.Get(t.Context(), "hits")
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), v)
+
+		require.NoError(t, sharded.Reset(t.Context(), "hits"))
+		v, err = plain.Get(t.Context(), "hits.0")
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), v)
+	})
+}
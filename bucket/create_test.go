@@ -0,0 +1,90 @@
+package bucket_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/bucket"
+	"github.com/mikluko/peanats/internal/xtestutil"
+)
+
+func TestBucket_CreatePurge(t *testing.T) {
+	kv := setupKeyValue(t)
+	b := bucket.NewBucket[counter](kv, bucket.BucketKeyPrefix("app"))
+
+	t.Run("create", func(t *testing.T) {
+		rev, err := b.Create(t.Context(), &putEntry{key: "claim", hdr: peanats.Header{}, val: &counter{N: 1}})
+		require.NoError(t, err)
+		assert.NotZero(t, rev)
+
+		_, err = b.Create(t.Context(), &putEntry{key: "claim", hdr: peanats.Header{}, val: &counter{N: 2}})
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, bucket.ErrKeyExists))
+
+		ent, err := b.Get(t.Context(), "claim")
+		require.NoError(t, err)
+		assert.Equal(t, 1, ent.Value().N)
+	})
+	t.Run("create over deleted", func(t *testing.T) {
+		_, err := b.Put(t.Context(), &putEntry{key: "released", hdr: peanats.Header{}, val: &counter{N: 1}})
+		require.NoError(t, err)
+		require.NoError(t, b.Delete(t.Context(), "released"))
+
+		_, err = b.Create(t.Context(), &putEntry{key: "released", hdr: peanats.Header{}, val: &counter{N: 2}})
+		require.NoError(t, err)
+		ent, err := b.Get(t.Context(), "released")
+		require.NoError(t, err)
+		assert.Equal(t, 2, ent.Value().N)
+	})
+	t.Run("purge", func(t *testing.T) {
+		for i := range 3 {
+			_, err := b.Put(t.Context(), &putEntry{key: "purged", hdr: peanats.Header{}, val: &counter{N: i}})
+			require.NoError(t, err)
+		}
+		require.NoError(t, b.Purge(t.Context(), "purged"))
+
+		_, err := b.Get(t.Context(), "purged")
+		assert.True(t, errors.Is(err, bucket.ErrKeyNotFound))
+		history, err := b.History(t.Context(), "purged")
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		assert.Equal(t, jetstream.KeyValuePurge, history[0].Operation())
+	})
+	t.Run("purge deletes", func(t *testing.T) {
+		_, err := b.Put(t.Context(), &putEntry{key: "marked", hdr: peanats.Header{}, val: &counter{}})
+		require.NoError(t, err)
+		require.NoError(t, b.Delete(t.Context(), "marked"))
+
+		require.NoError(t, b.PurgeDeletes(t.Context(), jetstream.DeleteMarkersOlderThan(-1)))
+		_, err = b.History(t.Context(), "marked")
+		assert.True(t, errors.Is(err, bucket.ErrKeyNotFound))
+	})
+}
+
+func TestBucket_CreateTTL(t *testing.T) {
+	js := xtestutil.JetStream(t, xtestutil.Server(t))
+	kv := xtestutil.KeyValue(t, js, jetstream.KeyValueConfig{
+		Bucket:         "ttl",
+		LimitMarkerTTL: time.Second,
+	})
+	b := bucket.NewBucket[counter](kv)
+
+	_, err := b.Create(t.Context(), &putEntry{key: "lease", hdr: peanats.Header{}, val: &counter{N: 1}}, bucket.CreateTTL(time.Second))
+	require.NoError(t, err)
+	_, err = b.Get(t.Context(), "lease")
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		_, err := b.Get(t.Context(), "lease")
+		return errors.Is(err, bucket.ErrKeyNotFound)
+	}, 5*time.Second, 100*time.Millisecond)
+
+	_, err = b.Create(t.Context(), &putEntry{key: "lease", hdr: peanats.Header{}, val: &counter{N: 2}})
+	require.NoError(t, err)
+}
@@ -97,16 +97,34 @@ func encodeBucketEntryHeader[T any](h peanats.Header, v *T) ([]byte, error) {
 }
 
 func decodeBucketEntryHeader[T any](b []byte) (h peanats.Header, v *T, err error) {
-	r := multipart.NewReader(bytes.NewReader(b), bucketEntryHeaderBoundary)
-	p, err := r.NextPart()
+	h, data, err := decodeBucketEntryPayload(b)
 	if err != nil {
 		return nil, nil, err
 	}
-	h = p.Header
 	c, err := codec.ForHeader(h)
 	if err != nil {
 		return nil, nil, err
 	}
+	v = new(T)
+	err = c.Unmarshal(data, v)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, v, nil
+}
+
+// decodeBucketEntryPayload splits an entry into its header and the
+// decompressed payload.
+func decodeBucketEntryPayload(b []byte) (peanats.Header, []byte, error) {
+	r := multipart.NewReader(bytes.NewReader(b), bucketEntryHeaderBoundary)
+	p, err := r.NextPart()
+	if err != nil {
+		return nil, nil, err
+	}
+	h := peanats.Header(p.Header)
+	if _, err := codec.ForHeader(h); err != nil {
+		return nil, nil, err
+	}
 	buf := new(bytes.Buffer)
 	_, _ = buf.ReadFrom(p)
 	data := buf.Bytes()
@@ -120,10 +138,5 @@ func decodeBucketEntryHeader[T any](b []byte) (h peanats.Header, v *T, err error
 			return nil, nil, err
 		}
 	}
-	v = new(T)
-	err = c.Unmarshal(data, v)
-	if err != nil {
-		return nil, nil, err
-	}
-	return h, v, nil
+	return h, data, nil
 }
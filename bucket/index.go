@@ -0,0 +1,360 @@
+package bucket
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrUnknownIndex is returned by FindBy for an index that was not registered.
+var ErrUnknownIndex = errors.New("unknown index")
+
+// IndexFunc extracts the values a value of T is indexed by. Empty values are
+// not indexed.
+type IndexFunc[T any] func(*T) []string
+
+// IndexedBucket is a bucket maintaining secondary indexes of its values.
+type IndexedBucket[T any] interface {
+	Bucket[T]
+	// FindBy iterates over the entries whose values are indexed by value in
+	// the named index. Iteration stops at the first error, which is yielded.
+	FindBy(ctx context.Context, index, value string) iter.Seq2[Entry[T], error]
+	// Rebuild makes the indexes match the current entries. It is meant for
+	// new indexes and for recovering from interrupted writes, and should run
+	// while nobody writes to the bucket.
+	Rebuild(ctx context.Context) error
+}
+
+type IndexOption[T any] func(*indexParams[T])
+
+type indexParams[T any] struct {
+	indexes  map[string]IndexFunc[T]
+	attempts uint64
+}
+
+// IndexBy registers an index. The name must be a valid key token, i.e. it
+// must not contain dots or wildcards.
+func IndexBy[T any](name string, fn IndexFunc[T]) IndexOption[T] {
+	return func(p *indexParams[T]) {
+		p.indexes[name] = fn
+	}
+}
+
+// IndexAttempts sets how many times Put is tried when racing with concurrent
+// writers before giving up with ErrConflict.
+func IndexAttempts[T any](n uint64) IndexOption[T] {
+	return func(p *indexParams[T]) {
+		p.attempts = max(n, 1)
+	}
+}
+
+// NewIndexedBucket wraps b with secondary indexes kept in the companion
+// key-value store idx. Index keys are written before and removed after the
+// entry they refer to, so an interrupted write leaves at most stale index
+// keys; FindBy skips those.
+func NewIndexedBucket[T any](b Bucket[T], idx jetstream.KeyValue, opts ...IndexOption[T]) IndexedBucket[T] {
+	p := indexParams[T]{
+		indexes:  make(map[string]IndexFunc[T]),
+		attempts: DefaultMutateAttempts,
+	}
+	for _, o := range opts {
+		o(&p)
+	}
+	return &indexedBucketImpl[T]{Bucket: b, idx: idx, params: p}
+}
+
+type indexedBucketImpl[T any] struct {
+	Bucket[T]
+	idx    jetstream.KeyValue
+	params indexParams[T]
+}
+
+func indexKey(index, value, key string) string {
+	return fmt.Sprintf("%s.%s.%s", index, base64.RawURLEncoding.EncodeToString([]byte(value)), key)
+}
+
+// indexKeys returns the index keys of the value stored under key.
+func (s *indexedBucketImpl[T]) indexKeys(key string, value *T) []string {
+	if value == nil {
+		return nil
+	}
+	var res []string
+	for name, fn := range s.params.indexes {
+		for _, v := range fn(value) {
+			if v != "" {
+				res = append(res, indexKey(name, v, key))
+			}
+		}
+	}
+	slices.Sort(res)
+	return slices.Compact(res)
+}
+
+type staleKey struct {
+	key      string
+	revision uint64
+}
+
+// prepare writes the index keys of the new value and returns the index keys
+// of the current value that are to be removed once the entry is written. The
+// revisions of those are noted first: a concurrent writer putting one of them
+// again bumps its revision, which keeps it from being removed. The index keys
+// written so far are returned even on error, to be rolled back.
+func (s *indexedBucketImpl[T]) prepare(ctx context.Context, cur Entry[T], key string, value *T) (stale, added []staleKey, err error) {
+	keys := s.indexKeys(key, value)
+	var held []string
+	if cur != nil {
+		held = s.indexKeys(key, cur.Value())
+	}
+	for _, k := range held {
+		if slices.Contains(keys, k) {
+			continue
+		}
+		ent, err := s.idx.Get(ctx, k)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		stale = append(stale, staleKey{key: k, revision: ent.Revision()})
+	}
+	for _, k := range keys {
+		rev, err := s.idx.Put(ctx, k, nil)
+		if err != nil {
+			return nil, added, err
+		}
+		if !slices.Contains(held, k) {
+			added = append(added, staleKey{key: k, revision: rev})
+		}
+	}
+	return stale, added, nil
+}
+
+// rollback removes the index keys written for an entry that failed to be
+// written, unless the entry as it is now holds them. A concurrent writer
+// putting one of them again keeps it, like in cleanup.
+func (s *indexedBucketImpl[T]) rollback(ctx context.Context, key string, added []staleKey) error {
+	if len(added) == 0 {
+		return nil
+	}
+	cur, err := s.current(ctx, key)
+	if err != nil {
+		return err
+	}
+	var held []string
+	if cur != nil {
+		held = s.indexKeys(key, cur.Value())
+	}
+	return s.cleanup(ctx, slices.DeleteFunc(added, func(k staleKey) bool {
+		return slices.Contains(held, k.key)
+	}))
+}
+
+func (s *indexedBucketImpl[T]) cleanup(ctx context.Context, stale []staleKey) error {
+	for _, k := range stale {
+		err := s.idx.Delete(ctx, k.key, jetstream.LastRevision(k.revision))
+		if err != nil && !IsConflict(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *indexedBucketImpl[T]) current(ctx context.Context, key string) (Entry[T], error) {
+	cur, err := s.Bucket.Get(ctx, key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil, nil
+	}
+	return cur, err
+}
+
+// Put writes the entry conditionally on the revision its previous value was
+// indexed at, retrying when a concurrent writer gets in between.
+func (s *indexedBucketImpl[T]) Put(ctx context.Context, entry PutEntry[T]) (uint64, error) {
+	var err error
+	for range s.params.attempts {
+		var rev uint64
+		rev, err = s.put(ctx, entry)
+		if !IsConflict(err) && !errors.Is(err, ErrKeyExists) {
+			return rev, err
+		}
+	}
+	return 0, fmt.Errorf("%w: %s: %w", ErrConflict, entry.Key(), err)
+}
+
+func (s *indexedBucketImpl[T]) put(ctx context.Context, entry PutEntry[T]) (uint64, error) {
+	cur, err := s.current(ctx, entry.Key())
+	if err != nil {
+		return 0, err
+	}
+	stale, added, err := s.prepare(ctx, cur, entry.Key(), entry.Value())
+	var rev uint64
+	switch {
+	case err != nil:
+	case cur == nil:
+		rev, err = s.Bucket.Create(ctx, entry)
+	default:
+		rev, err = s.Bucket.Update(ctx, updateEntry[T]{
+			key:      entry.Key(),
+			header:   entry.Header(),
+			value:    entry.Value(),
+			revision: cur.Revision(),
+		})
+	}
+	if err != nil {
+		return 0, errors.Join(err, s.rollback(ctx, entry.Key(), added))
+	}
+	return rev, s.cleanup(ctx, stale)
+}
+
+func (s *indexedBucketImpl[T]) Update(ctx context.Context, entry UpdateEntry[T]) (uint64, error) {
+	cur, err := s.current(ctx, entry.Key())
+	if err != nil {
+		return 0, err
+	}
+	if cur != nil && cur.Revision() != entry.Revision() {
+		// bound to conflict, leave it to the bucket to say so
+		cur = nil
+	}
+	stale, added, err := s.prepare(ctx, cur, entry.Key(), entry.Value())
+	var rev uint64
+	if err == nil {
+		rev, err = s.Bucket.Update(ctx, entry)
+	}
+	if err != nil {
+		return 0, errors.Join(err, s.rollback(ctx, entry.Key(), added))
+	}
+	return rev, s.cleanup(ctx, stale)
+}
+
+func (s *indexedBucketImpl[T]) Create(ctx context.Context, entry PutEntry[T], opts ...CreateOption) (uint64, error) {
+	_, added, err := s.prepare(ctx, nil, entry.Key(), entry.Value())
+	var rev uint64
+	if err == nil {
+		rev, err = s.Bucket.Create(ctx, entry, opts...)
+	}
+	if err != nil {
+		return 0, errors.Join(err, s.rollback(ctx, entry.Key(), added))
+	}
+	return rev, nil
+}
+
+func (s *indexedBucketImpl[T]) Delete(ctx context.Context, key string, opts ...DeleteOption) error {
+	return s.remove(ctx, key, func() error {
+		return s.Bucket.Delete(ctx, key, opts...)
+	})
+}
+
+func (s *indexedBucketImpl[T]) Purge(ctx context.Context, key string, opts ...DeleteOption) error {
+	return s.remove(ctx, key, func() error {
+		return s.Bucket.Purge(ctx, key, opts...)
+	})
+}
+
+func (s *indexedBucketImpl[T]) remove(ctx context.Context, key string, fn func() error) error {
+	cur, err := s.current(ctx, key)
+	if err != nil {
+		return err
+	}
+	stale, _, err := s.prepare(ctx, cur, key, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return s.cleanup(ctx, stale)
+}
+
+// listKeys collects the keys of the index store matching filter. The keys are
+// collected up front so that the lister is always drained.
+func (s *indexedBucketImpl[T]) listKeys(ctx context.Context, filter string) ([]string, error) {
+	kl, err := s.idx.ListKeysFiltered(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for k := range kl.Keys() {
+		keys = append(keys, k)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *indexedBucketImpl[T]) FindBy(ctx context.Context, index, value string) iter.Seq2[Entry[T], error] {
+	return func(yield func(Entry[T], error) bool) {
+		fn, ok := s.params.indexes[index]
+		if !ok {
+			yield(nil, fmt.Errorf("%w: %s", ErrUnknownIndex, index))
+			return
+		}
+		if value == "" {
+			return
+		}
+		prefix := indexKey(index, value, "")
+		keys, err := s.listKeys(ctx, prefix+">")
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		for _, k := range keys {
+			e, err := s.Bucket.Get(ctx, strings.TrimPrefix(k, prefix))
+			if errors.Is(err, jetstream.ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			// skip stale index keys
+			if !slices.Contains(fn(e.Value()), value) {
+				continue
+			}
+			if !yield(e, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (s *indexedBucketImpl[T]) Rebuild(ctx context.Context) error {
+	want := make(map[string]bool)
+	for e, err := range s.Bucket.Entries(ctx, "") {
+		if err != nil {
+			return err
+		}
+		for _, k := range s.indexKeys(e.Key(), e.Value()) {
+			want[k] = true
+		}
+	}
+	for name := range s.params.indexes {
+		keys, err := s.listKeys(ctx, name+".>")
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if want[k] {
+				delete(want, k)
+				continue
+			}
+			if err := s.idx.Delete(ctx, k); err != nil {
+				return err
+			}
+		}
+	}
+	for k := range want {
+		if _, err := s.idx.Put(ctx, k, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package bucket_test
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/bucket"
+	"github.com/mikluko/peanats/internal/xtestutil"
+)
+
+type document struct {
+	Owner string   `json:"owner"`
+	Tags  []string `json:"tags"`
+}
+
+type documentEntry struct {
+	key string
+	val *document
+}
+
+func (e *documentEntry) Key() string            { return e.key }
+func (e *documentEntry) Header() peanats.Header { return peanats.Header{} }
+func (e *documentEntry) Value() *document       { return e.val }
+
+func setupIndexed(t *testing.T) (jetstream.KeyValue, jetstream.KeyValue) {
+	js := xtestutil.JetStream(t, xtestutil.Server(t))
+	return xtestutil.KeyValue(t, js, jetstream.KeyValueConfig{Bucket: "docs"}),
+		xtestutil.KeyValue(t, js, jetstream.KeyValueConfig{Bucket: "docs_idx"})
+}
+
+func TestIndexedBucket(t *testing.T) {
+	kv, idx := setupIndexed(t)
+	byOwner := bucket.IndexBy("owner", func(d *document) []string { return []string{d.Owner} })
+	byTag := bucket.IndexBy("tag", func(d *document) []string { return d.Tags })
+	b := bucket.NewIndexedBucket(bucket.NewBucket[document](kv), idx, byOwner, byTag)
+
+	find := func(index, value string) []string {
+		var keys []string
+		for e, err := range b.FindBy(t.Context(), index, value) {
+			require.NoError(t, err)
+			keys = append(keys, e.Key())
+		}
+		slices.Sort(keys)
+		return keys
+	}
+
+	_, err := b.Put(t.Context(), &documentEntry{key: "a.1", val: &document{Owner: "alice", Tags: []string{"x", "y"}}})
+	require.NoError(t, err)
+	_, err = b.Put(t.Context(), &documentEntry{key: "b.1", val: &document{Owner: "bob", Tags: []string{"y"}}})
+	require.NoError(t, err)
+	_, err = b.Create(t.Context(), &documentEntry{key: "a.2", val: &document{Owner: "alice", Tags: []string{"with space"}}})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a.1", "a.2"}, find("owner", "alice"))
+	assert.Equal(t, []string{"a.1", "b.1"}, find("tag", "y"))
+	assert.Equal(t, []string{"a.2"}, find("tag", "with space"))
+	assert.Empty(t, find("owner", "carol"))
+
+	t.Run("put moves index keys", func(t *testing.T) {
+		_, err := b.Put(t.Context(), &documentEntry{key: "b.1", val: &document{Owner: "alice"}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a.1", "a.2", "b.1"}, find("owner", "alice"))
+		assert.Empty(t, find("owner", "bob"))
+		assert.Equal(t, []string{"a.1"}, find("tag", "y"))
+	})
+	t.Run("update", func(t *testing.T) {
+		cur, err := b.Get(t.Context(), "a.2")
+		require.NoError(t, err)
+		_, err = b.Update(t.Context(), &testUpdateEntry{key: "a.2", val: &document{Owner: "carol"}, rev: cur.Revision()})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a.2"}, find("owner", "carol"))
+
+		_, err = b.Update(t.Context(), &testUpdateEntry{key: "a.2", val: &document{Owner: "dave"}, rev: cur.Revision()})
+		assert.True(t, bucket.IsConflict(err))
+		assert.Empty(t, find("owner", "dave"))
+		assert.Equal(t, []string{"a.2"}, find("owner", "carol"))
+	})
+	t.Run("failed write leaves no index keys", func(t *testing.T) {
+		before, err := idx.Keys(t.Context())
+		require.NoError(t, err)
+		cur, err := b.Get(t.Context(), "a.2")
+		require.NoError(t, err)
+		_, err = b.Create(t.Context(), &documentEntry{key: "a.2", val: &document{Owner: "carol", Tags: []string{"z"}}})
+		assert.ErrorIs(t, err, bucket.ErrKeyExists)
+		_, err = b.Update(t.Context(), &testUpdateEntry{key: "a.2", val: &document{Owner: "frank"}, rev: cur.Revision() - 1})
+		assert.True(t, bucket.IsConflict(err))
+
+		after, err := idx.Keys(t.Context())
+		require.NoError(t, err)
+		assert.ElementsMatch(t, before, after)
+		assert.Equal(t, []string{"a.2"}, find("owner", "carol"))
+	})
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, b.Delete(t.Context(), "a.1"))
+		assert.Equal(t, []string{"b.1"}, find("owner", "alice"))
+		assert.Empty(t, find("tag", "x"))
+	})
+	t.Run("unknown index", func(t *testing.T) {
+		for _, err := range b.FindBy(t.Context(), "color", "red") {
+			assert.True(t, errors.Is(err, bucket.ErrUnknownIndex))
+		}
+	})
+	t.Run("rebuild", func(t *testing.T) {
+		// written behind the back of the index
+		raw := bucket.NewBucket[document](kv)
+		_, err := raw.Put(t.Context(), &documentEntry{key: "c.1", val: &document{Owner: "erin"}})
+		require.NoError(t, err)
+		require.NoError(t, raw.Delete(t.Context(), "b.1"))
+		assert.Empty(t, find("owner", "erin"))
+
+		byColor := bucket.IndexBy("color", func(d *document) []string { return []string{"red"} })
+		b := bucket.NewIndexedBucket(bucket.NewBucket[document](kv), idx, byOwner, byTag, byColor)
+		require.NoError(t, b.Rebuild(t.Context()))
+
+		assert.Equal(t, []string{"c.1"}, find("owner", "erin"))
+		keys, err := idx.Keys(t.Context())
+		require.NoError(t, err)
+		for _, k := range keys {
+			assert.NotContains(t, k, "b.1")
+		}
+		n := 0
+		for _, err := range b.FindBy(t.Context(), "color", "red") {
+			require.NoError(t, err)
+			n++
+		}
+		assert.Equal(t, 2, n)
+	})
+}
+
+func TestIndexedBucket_Concurrent(t *testing.T) {
+	kv, idx := setupIndexed(t)
+	byOwner := bucket.IndexBy("owner", func(d *document) []string { return []string{d.Owner} })
+	b := bucket.NewIndexedBucket(bucket.NewBucket[document](kv), idx, byOwner, bucket.IndexAttempts[document](100))
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := b.Put(t.Context(), &documentEntry{key: "doc", val: &document{Owner: fmt.Sprint("owner", i)}})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	cur, err := b.Get(t.Context(), "doc")
+	require.NoError(t, err)
+	for i := range 8 {
+		owner := fmt.Sprint("owner", i)
+		n := 0
+		for e, err := range b.FindBy(t.Context(), "owner", owner) {
+			require.NoError(t, err)
+			assert.Equal(t, "doc", e.Key())
+			n++
+		}
+		if owner == cur.Value().Owner {
+			assert.Equal(t, 1, n)
+		} else {
+			assert.Zero(t, n)
+		}
+	}
+}
+
+type testUpdateEntry struct {
+	key string
+	val *document
+	rev uint64
+}
+
+func (e *testUpdateEntry) Key() string            { return e.key }
+func (e *testUpdateEntry) Header() peanats.Header { return peanats.Header{} }
+func (e *testUpdateEntry) Value() *document       { return e.val }
+func (e *testUpdateEntry) Revision() uint64       { return e.rev }
@@ -0,0 +1,80 @@
+package bucket
+
+import (
+	"context"
+	"errors"
+	"iter"
+)
+
+type ListOption func(*listParams)
+
+type listParams struct {
+	metaOnly bool
+}
+
+// ListMetaOnly makes Entries skip fetching and decoding the values: the
+// entries carry neither value nor header.
+func ListMetaOnly() ListOption {
+	return func(p *listParams) {
+		p.metaOnly = true
+	}
+}
+
+// Keys iterates over the keys matching filter, e.g. "orders.>". An empty
+// filter matches all keys. Deleted keys are skipped. Iteration stops at the
+// first error, which is yielded.
+func (s *bucketImpl[T]) Keys(ctx context.Context, filter string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		for e, err := range s.Entries(ctx, filter, ListMetaOnly()) {
+			if err != nil {
+				yield("", err)
+				return
+			}
+			if !yield(e.Key(), nil) {
+				return
+			}
+		}
+	}
+}
+
+// Entries iterates over the current entries of the keys matching filter. An
+// empty filter matches all keys. Deleted keys are skipped. Iteration stops at
+// the first error, which is yielded.
+func (s *bucketImpl[T]) Entries(ctx context.Context, filter string, opts ...ListOption) iter.Seq2[Entry[T], error] {
+	p := listParams{}
+	for _, o := range opts {
+		o(&p)
+	}
+	if filter == "" {
+		filter = ">"
+	}
+	wopts := []WatcherOption{WatcherIgnoreDeletes()}
+	if p.metaOnly {
+		wopts = append(wopts, WatcherMetaOnly())
+	}
+	return func(yield func(Entry[T], error) bool) {
+		w, err := s.Watch(ctx, filter, wopts...)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer func() { _ = w.Stop() }()
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+			e, err := w.Next()
+			if errors.Is(err, ErrInitialValuesOver) {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(e, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package bucket_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/bucket"
+)
+
+func TestBucket_List(t *testing.T) {
+	kv := setupKeyValue(t)
+	b := bucket.NewBucket[counter](kv, bucket.BucketKeyPrefix("app"))
+	other := bucket.NewBucket[counter](kv, bucket.BucketKeyPrefix("other"))
+	for i, key := range []string{"a.x", "a.y", "b.z", "gone"} {
+		_, err := b.Put(t.Context(), &putEntry{key: key, hdr: peanats.Header{}, val: &counter{N: i}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, b.Delete(t.Context(), "gone"))
+	_, err := other.Put(t.Context(), &putEntry{key: "a.x", hdr: peanats.Header{}, val: &counter{}})
+	require.NoError(t, err)
+
+	collect := func(seq func(func(string, error) bool)) []string {
+		var keys []string
+		for k, err := range seq {
+			require.NoError(t, err)
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		return keys
+	}
+
+	t.Run("keys", func(t *testing.T) {
+		assert.Equal(t, []string{"a.x", "a.y", "b.z"}, collect(b.Keys(t.Context(), "")))
+		assert.Equal(t, []string{"a.x", "a.y"}, collect(b.Keys(t.Context(), "a.*")))
+	})
+	t.Run("entries", func(t *testing.T) {
+		values := map[string]int{}
+		for e, err := range b.Entries(t.Context(), "") {
+			require.NoError(t, err)
+			values[e.Key()] = e.Value().N
+		}
+		assert.Equal(t, map[string]int{"a.x": 0, "a.y": 1, "b.z": 2}, values)
+	})
+	t.Run("meta only", func(t *testing.T) {
+		n := 0
+		for e, err := range b.Entries(t.Context(), "b.>", bucket.ListMetaOnly()) {
+			require.NoError(t, err)
+			assert.Equal(t, "b.z", e.Key())
+			assert.Nil(t, e.Value())
+			assert.NotZero(t, e.Revision())
+			n++
+		}
+		assert.Equal(t, 1, n)
+	})
+	t.Run("early break", func(t *testing.T) {
+		for range b.Keys(t.Context(), "") {
+			break
+		}
+	})
+	t.Run("empty", func(t *testing.T) {
+		empty := bucket.NewBucket[counter](kv, bucket.BucketKeyPrefix("empty"))
+		assert.Empty(t, collect(empty.Keys(t.Context(), "")))
+	})
+	t.Run("error", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		var err error
+		for _, err = range b.Keys(ctx, "") {
+		}
+		require.Error(t, err)
+		assert.False(t, errors.Is(err, bucket.ErrInitialValuesOver))
+	})
+}
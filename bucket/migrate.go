@@ -0,0 +1,253 @@
+package bucket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/mikluko/peanats/codec"
+)
+
+// ErrMigrateType is returned by Migrate without a transform function when
+// the old type can not be written as the new one.
+var ErrMigrateType = errors.New("no transform between types")
+
+// MigrateProgress tells how far a migration got.
+type MigrateProgress struct {
+	// Key is the key processed last.
+	Key string
+	// Total is the number of keys to process, Done the number of keys
+	// processed so far, including those skipped thanks to a checkpoint.
+	Total, Done int
+	// Migrated is the number of entries written back.
+	Migrated int
+}
+
+// MigrateCheckpoint keeps the last key a migration completed, so that an
+// interrupted migration resumes where it stopped.
+type MigrateCheckpoint interface {
+	// Load returns the saved key, or an empty string if there is none.
+	Load(context.Context) (string, error)
+	Save(context.Context, string) error
+}
+
+type MigrateOption func(*migrateParams)
+
+type migrateParams struct {
+	contentType     codec.ContentType
+	contentEncoding codec.ContentEncoding
+	setEncoding     bool
+	prefix          string
+	checkpoint      MigrateCheckpoint
+	every           int
+	attempts        uint64
+	onProgress      func(MigrateProgress)
+}
+
+const DefaultMigrateCheckpointEvery = 100
+
+// MigrateContentType sets the content type entries are re-encoded with. By
+// default entries keep their content type.
+func MigrateContentType(c codec.ContentType) MigrateOption {
+	return func(p *migrateParams) {
+		p.contentType = c
+	}
+}
+
+// MigrateContentEncoding sets the compression algorithm entries are
+// re-encoded with. Zero removes compression. By default entries keep their
+// encoding.
+func MigrateContentEncoding(e codec.ContentEncoding) MigrateOption {
+	return func(p *migrateParams) {
+		p.contentEncoding = e
+		p.setEncoding = true
+	}
+}
+
+// MigrateKeyPrefix limits the migration to the keys of a bucket created with
+// BucketKeyPrefix.
+func MigrateKeyPrefix(prefix string) MigrateOption {
+	return func(p *migrateParams) {
+		p.prefix = prefix
+	}
+}
+
+// MigrateWithCheckpoint makes the migration resume after the key saved in c
+// and save its progress there every n keys, as well as when it stops.
+func MigrateWithCheckpoint(c MigrateCheckpoint, n int) MigrateOption {
+	return func(p *migrateParams) {
+		p.checkpoint = c
+		p.every = max(n, 1)
+	}
+}
+
+// MigrateOnProgress sets a function called after every processed key.
+func MigrateOnProgress(fn func(MigrateProgress)) MigrateOption {
+	return func(p *migrateParams) {
+		p.onProgress = fn
+	}
+}
+
+// Migrate rewrites the entries of kv: each one is decoded as Old, converted
+// with fn and re-encoded as New with the target content type and encoding.
+// Entries are written back with Update, so a concurrent change of an entry
+// is migrated anew rather than overwritten. A nil fn requires Old and New to
+// be the same type; a nil result of fn leaves the entry as is. Keys are
+// processed in lexical order, which makes checkpoints possible. Migrate stops
+// at the first error, reporting the key that failed.
+func Migrate[Old, New any](ctx context.Context, kv jetstream.KeyValue, fn func(*Old) (*New, error), opts ...MigrateOption) (MigrateProgress, error) {
+	p := migrateParams{
+		every:      DefaultMigrateCheckpointEvery,
+		attempts:   DefaultMutateAttempts,
+		onProgress: func(MigrateProgress) {},
+	}
+	for _, o := range opts {
+		o(&p)
+	}
+	if fn == nil {
+		fn = func(v *Old) (*New, error) {
+			if n, ok := any(v).(*New); ok {
+				return n, nil
+			}
+			return nil, fmt.Errorf("%w: %T to %T", ErrMigrateType, v, new(New))
+		}
+	}
+	filter := ">"
+	if p.prefix != "" {
+		filter = p.prefix + ".>"
+	}
+	var progress MigrateProgress
+	kl, err := kv.ListKeysFiltered(ctx, filter)
+	if err != nil {
+		return progress, err
+	}
+	var keys []string
+	for k := range kl.Keys() {
+		keys = append(keys, k)
+	}
+	if err := ctx.Err(); err != nil {
+		return progress, err
+	}
+	slices.Sort(keys)
+	progress.Total = len(keys)
+
+	var after string
+	if p.checkpoint != nil {
+		if after, err = p.checkpoint.Load(ctx); err != nil {
+			return progress, err
+		}
+	}
+	save := func() error {
+		if p.checkpoint == nil || progress.Key == "" {
+			return nil
+		}
+		return p.checkpoint.Save(ctx, progress.Key)
+	}
+	for _, key := range keys {
+		if after != "" && key <= after {
+			progress.Done++
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return progress, errors.Join(err, save())
+		}
+		migrated, err := migrateEntry(ctx, kv, key, fn, &p)
+		if err != nil {
+			return progress, errors.Join(fmt.Errorf("%s: %w", key, err), save())
+		}
+		progress.Key = key
+		progress.Done++
+		if migrated {
+			progress.Migrated++
+		}
+		p.onProgress(progress)
+		if progress.Done%p.every == 0 {
+			if err := save(); err != nil {
+				return progress, err
+			}
+		}
+	}
+	return progress, save()
+}
+
+func migrateEntry[Old, New any](ctx context.Context, kv jetstream.KeyValue, key string, fn func(*Old) (*New, error), p *migrateParams) (bool, error) {
+	var err error
+	for range p.attempts {
+		var migrated bool
+		migrated, err = migrateRevision(ctx, kv, key, fn, p)
+		if !IsConflict(err) {
+			return migrated, err
+		}
+	}
+	return false, fmt.Errorf("%w: %w", ErrConflict, err)
+}
+
+// migrateRevision rewrites the current revision of the entry.
+func migrateRevision[Old, New any](ctx context.Context, kv jetstream.KeyValue, key string, fn func(*Old) (*New, error), p *migrateParams) (bool, error) {
+	raw, err := kv.Get(ctx, key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	h, old, err := decodeBucketEntryHeader[Old](raw.Value())
+	if err != nil {
+		return false, err
+	}
+	v, err := fn(old)
+	if err != nil || v == nil {
+		return false, err
+	}
+	if p.contentType != 0 {
+		h.Set(codec.HeaderContentType, p.contentType.String())
+	}
+	if p.setEncoding {
+		h.Del(codec.HeaderContentEncoding)
+		if p.contentEncoding != 0 {
+			codec.SetContentEncoding(h, p.contentEncoding)
+		}
+	}
+	data, err := encodeBucketEntryHeader(h, v)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(data, raw.Value()) {
+		return false, nil
+	}
+	if _, err := kv.Update(ctx, key, data, raw.Revision()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// NewKeyValueCheckpoint creates a checkpoint kept under key in kv. It should
+// not be kept among the keys being migrated.
+func NewKeyValueCheckpoint(kv jetstream.KeyValue, key string) MigrateCheckpoint {
+	return &kvCheckpoint{kv: kv, key: key}
+}
+
+type kvCheckpoint struct {
+	kv  jetstream.KeyValue
+	key string
+}
+
+func (c *kvCheckpoint) Load(ctx context.Context) (string, error) {
+	ent, err := c.kv.Get(ctx, c.key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(ent.Value()), nil
+}
+
+func (c *kvCheckpoint) Save(ctx context.Context, key string) error {
+	_, err := c.kv.PutString(ctx, c.key, key)
+	return err
+}
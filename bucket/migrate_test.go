@@ -0,0 +1,121 @@
+package bucket_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/bucket"
+	"github.com/mikluko/peanats/codec"
+)
+
+type counterV2 struct {
+	Count int    `json:"count" msgpack:"count"`
+	Note  string `json:"note,omitempty" msgpack:"note,omitempty"`
+}
+
+type memoryCheckpoint struct {
+	key   string
+	saves int
+}
+
+func (c *memoryCheckpoint) Load(context.Context) (string, error) { return c.key, nil }
+
+func (c *memoryCheckpoint) Save(_ context.Context, key string) error {
+	c.key = key
+	c.saves++
+	return nil
+}
+
+func TestMigrate(t *testing.T) {
+	kv := setupKeyValue(t)
+	b := bucket.NewBucket[counter](kv, bucket.BucketKeyPrefix("app"))
+	for i, key := range []string{"a", "b", "c", "d"} {
+		_, err := b.Put(t.Context(), &putEntry{key: key, hdr: peanats.Header{"X-Seq": []string{"1"}}, val: &counter{N: i}})
+		require.NoError(t, err)
+	}
+	_, err := kv.PutString(t.Context(), "other", "not an entry")
+	require.NoError(t, err)
+
+	t.Run("re-encode", func(t *testing.T) {
+		var seen []string
+		progress, err := bucket.Migrate[counter, counter](t.Context(), kv, nil,
+			bucket.MigrateKeyPrefix("app"),
+			bucket.MigrateContentType(codec.Msgpack),
+			bucket.MigrateContentEncoding(codec.Zstd),
+			bucket.MigrateOnProgress(func(p bucket.MigrateProgress) { seen = append(seen, p.Key) }))
+		require.NoError(t, err)
+		assert.Equal(t, bucket.MigrateProgress{Key: "app.d", Total: 4, Done: 4, Migrated: 4}, progress)
+		assert.Equal(t, []string{"app.a", "app.b", "app.c", "app.d"}, seen)
+
+		raw, err := kv.Get(t.Context(), "app.c")
+		require.NoError(t, err)
+		assert.Contains(t, string(raw.Value()), "Content-Type: application/msgpack")
+		assert.Contains(t, string(raw.Value()), "Content-Encoding: zstd")
+		ent, err := b.Get(t.Context(), "c")
+		require.NoError(t, err)
+		assert.Equal(t, 2, ent.Value().N)
+		assert.Equal(t, "1", ent.Header().Get("X-Seq"))
+
+		progress, err = bucket.Migrate[counter, counter](t.Context(), kv, nil,
+			bucket.MigrateKeyPrefix("app"),
+			bucket.MigrateContentType(codec.Msgpack),
+			bucket.MigrateContentEncoding(codec.Zstd))
+		require.NoError(t, err)
+		assert.Zero(t, progress.Migrated)
+	})
+	t.Run("type mismatch", func(t *testing.T) {
+		_, err := bucket.Migrate[counter, counterV2](t.Context(), kv, nil, bucket.MigrateKeyPrefix("app"))
+		assert.True(t, errors.Is(err, bucket.ErrMigrateType))
+	})
+	t.Run("transform with checkpoint", func(t *testing.T) {
+		cp := &memoryCheckpoint{}
+		boom := errors.New("boom")
+		upgrade := func(fail string) func(*counter) (*counterV2, error) {
+			return func(c *counter) (*counterV2, error) {
+				if c.N == 2 && fail != "" {
+					return nil, boom
+				}
+				return &counterV2{Count: c.N, Note: "upgraded"}, nil
+			}
+		}
+		opts := []bucket.MigrateOption{
+			bucket.MigrateKeyPrefix("app"),
+			bucket.MigrateContentEncoding(0),
+			bucket.MigrateWithCheckpoint(cp, 1),
+		}
+
+		progress, err := bucket.Migrate(t.Context(), kv, upgrade("c"), opts...)
+		require.ErrorIs(t, err, boom)
+		assert.Contains(t, err.Error(), "app.c")
+		assert.Equal(t, 2, progress.Migrated)
+		assert.Equal(t, "app.b", cp.key)
+
+		progress, err = bucket.Migrate(t.Context(), kv, upgrade(""), opts...)
+		require.NoError(t, err)
+		assert.Equal(t, bucket.MigrateProgress{Key: "app.d", Total: 4, Done: 4, Migrated: 2}, progress)
+		assert.Equal(t, "app.d", cp.key)
+
+		v2 := bucket.NewBucket[counterV2](kv, bucket.BucketKeyPrefix("app"))
+		for i, key := range []string{"a", "b", "c", "d"} {
+			ent, err := v2.Get(t.Context(), key)
+			require.NoError(t, err)
+			assert.Equal(t, counterV2{Count: i, Note: "upgraded"}, *ent.Value())
+			assert.Empty(t, ent.Header().Get(codec.HeaderContentEncoding))
+		}
+	})
+	t.Run("key-value checkpoint", func(t *testing.T) {
+		cp := bucket.NewKeyValueCheckpoint(kv, "migration")
+		key, err := cp.Load(t.Context())
+		require.NoError(t, err)
+		assert.Empty(t, key)
+		require.NoError(t, cp.Save(t.Context(), "app.b"))
+		key, err = cp.Load(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, "app.b", key)
+	})
+}
@@ -0,0 +1,142 @@
+package bucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/mikluko/peanats"
+)
+
+// ErrConflict is returned by Mutate when every attempt lost the race against
+// a concurrent writer.
+var ErrConflict = errors.New("conflicting concurrent update")
+
+type MutateOption func(*mutateParams)
+
+type mutateParams struct {
+	attempts uint64
+	delay    peanats.DelayPolicy
+	create   bool
+}
+
+const DefaultMutateAttempts = 10
+
+// MutateAttempts sets how many times the read-modify-write cycle is tried
+// before giving up with ErrConflict.
+func MutateAttempts(n uint64) MutateOption {
+	return func(p *mutateParams) {
+		p.attempts = max(n, 1)
+	}
+}
+
+// MutateBackoff sets the pause between attempts. By default the pause grows
+// exponentially from 10ms up to 1s, with jitter.
+func MutateBackoff(policy peanats.DelayPolicy) MutateOption {
+	return func(p *mutateParams) {
+		p.delay = policy
+	}
+}
+
+// MutateCreate makes Mutate start from the zero value of T when the key does
+// not exist or was deleted, instead of returning jetstream.ErrKeyNotFound.
+func MutateCreate() MutateOption {
+	return func(p *mutateParams) {
+		p.create = true
+	}
+}
+
+// Mutate applies fn to the current value of key and writes the result back,
+// provided no one else updated the key in the meantime. On a conflict the
+// value is read again and fn applied anew, so fn must be free of side
+// effects. The entry header is preserved. An error returned by fn aborts
+// Mutate and is returned as is.
+func Mutate[T any](ctx context.Context, b Bucket[T], key string, fn func(*T) error, opts ...MutateOption) (uint64, error) {
+	p := mutateParams{
+		attempts: DefaultMutateAttempts,
+		delay:    jitterDelay{base: 10 * time.Millisecond, max: time.Second},
+	}
+	for _, o := range opts {
+		o(&p)
+	}
+	var err error
+	for attempt := uint64(1); attempt <= p.attempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			case <-time.After(p.delay.Delay(attempt - 1)):
+			}
+		}
+		var rev uint64
+		rev, err = mutate(ctx, b, key, fn, p.create)
+		if !IsConflict(err) {
+			return rev, err
+		}
+	}
+	return 0, fmt.Errorf("%w: %s: %w", ErrConflict, key, err)
+}
+
+func mutate[T any](ctx context.Context, b Bucket[T], key string, fn func(*T) error, create bool) (uint64, error) {
+	var (
+		rev    uint64
+		value  = new(T)
+		header = peanats.Header{}
+	)
+	ent, err := b.Get(ctx, key)
+	switch {
+	case err == nil:
+		rev, value, header = ent.Revision(), ent.Value(), peanats.CloneHeader(ent.Header())
+	case errors.Is(err, jetstream.ErrKeyNotFound) && create:
+		// a deleted key has to be updated on top of its delete marker
+		ent, err = b.GetLatestRevision(ctx, key)
+		if err == nil {
+			rev = ent.Revision()
+		} else if !errors.Is(err, jetstream.ErrKeyNotFound) {
+			return 0, err
+		}
+	default:
+		return 0, err
+	}
+	if err := fn(value); err != nil {
+		return 0, err
+	}
+	return b.Update(ctx, updateEntry[T]{key: key, header: header, value: value, revision: rev})
+}
+
+// IsConflict tells whether err reports that the key changed since the
+// revision an update or a delete was conditioned on.
+func IsConflict(err error) bool {
+	var apiErr *jetstream.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence
+}
+
+type updateEntry[T any] struct {
+	key      string
+	header   peanats.Header
+	value    *T
+	revision uint64
+}
+
+func (e updateEntry[T]) Key() string            { return e.key }
+func (e updateEntry[T]) Header() peanats.Header { return e.header }
+func (e updateEntry[T]) Value() *T              { return e.value }
+func (e updateEntry[T]) Revision() uint64       { return e.revision }
+
+// jitterDelay doubles the delay with every attempt up to max and picks a
+// random pause up to that, which spreads competing writers apart.
+type jitterDelay struct {
+	base, max time.Duration
+}
+
+func (d jitterDelay) Delay(attempt uint64) time.Duration {
+	limit := d.max
+	if attempt < 32 {
+		limit = min(d.base<<(attempt-1), d.max)
+	}
+	return time.Duration(rand.Int64N(int64(limit)) + 1)
+}
@@ -0,0 +1,122 @@
+package bucket_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/bucket"
+	"github.com/mikluko/peanats/internal/xtestutil"
+)
+
+type counter struct {
+	N int `json:"n"`
+}
+
+func setupKeyValue(t *testing.T) jetstream.KeyValue {
+	js := xtestutil.JetStream(t, xtestutil.Server(t))
+	return xtestutil.KeyValue(t, js, jetstream.KeyValueConfig{Bucket: "test", History: 5})
+}
+
+type constantDelay struct{}
+
+func (constantDelay) Delay(uint64) time.Duration { return 0 }
+
+type conflictingBucket struct {
+	bucket.Bucket[counter]
+}
+
+func (conflictingBucket) Update(context.Context, bucket.UpdateEntry[counter]) (uint64, error) {
+	return 0, &jetstream.APIError{ErrorCode: jetstream.JSErrCodeStreamWrongLastSequence, Code: 400}
+}
+
+func TestMutate(t *testing.T) {
+	incr := func(c *counter) error {
+		c.N++
+		return nil
+	}
+	t.Run("concurrent", func(t *testing.T) {
+		b := bucket.NewBucket[counter](setupKeyValue(t))
+		_, err := bucket.Mutate(t.Context(), b, "count", incr, bucket.MutateCreate())
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 5; j++ {
+					_, err := bucket.Mutate(t.Context(), b, "count", incr, bucket.MutateAttempts(100))
+					assert.NoError(t, err)
+				}
+			}()
+		}
+		wg.Wait()
+		ent, err := b.Get(t.Context(), "count")
+		require.NoError(t, err)
+		assert.Equal(t, 41, ent.Value().N)
+	})
+	t.Run("preserves header", func(t *testing.T) {
+		b := bucket.NewBucket[counter](setupKeyValue(t))
+		_, err := b.Put(t.Context(), &putEntry{key: "count", hdr: peanats.Header{"X-Owner": []string{"me"}}, val: &counter{N: 1}})
+		require.NoError(t, err)
+		rev, err := bucket.Mutate(t.Context(), b, "count", incr)
+		require.NoError(t, err)
+		ent, err := b.Get(t.Context(), "count")
+		require.NoError(t, err)
+		assert.Equal(t, rev, ent.Revision())
+		assert.Equal(t, 2, ent.Value().N)
+		assert.Equal(t, "me", ent.Header().Get("X-Owner"))
+	})
+	t.Run("missing key", func(t *testing.T) {
+		b := bucket.NewBucket[counter](setupKeyValue(t))
+		_, err := bucket.Mutate(t.Context(), b, "count", incr)
+		require.ErrorIs(t, err, jetstream.ErrKeyNotFound)
+	})
+	t.Run("create over deleted key", func(t *testing.T) {
+		b := bucket.NewBucket[counter](setupKeyValue(t))
+		_, err := bucket.Mutate(t.Context(), b, "count", incr, bucket.MutateCreate())
+		require.NoError(t, err)
+		require.NoError(t, b.Delete(t.Context(), "count"))
+		_, err = bucket.Mutate(t.Context(), b, "count", incr, bucket.MutateCreate())
+		require.NoError(t, err)
+		ent, err := b.Get(t.Context(), "count")
+		require.NoError(t, err)
+		assert.Equal(t, 1, ent.Value().N)
+	})
+	t.Run("callback error", func(t *testing.T) {
+		b := bucket.NewBucket[counter](setupKeyValue(t))
+		boom := errors.New("boom")
+		_, err := bucket.Mutate(t.Context(), b, "count", func(*counter) error { return boom }, bucket.MutateCreate())
+		require.ErrorIs(t, err, boom)
+	})
+	t.Run("conflict", func(t *testing.T) {
+		b := bucket.NewBucket[counter](setupKeyValue(t))
+		_, err := bucket.Mutate(t.Context(), b, "count", incr, bucket.MutateCreate())
+		require.NoError(t, err)
+		calls := 0
+		_, err = bucket.Mutate(t.Context(), conflictingBucket{b}, "count", func(c *counter) error {
+			calls++
+			return nil
+		}, bucket.MutateAttempts(3), bucket.MutateBackoff(constantDelay{}))
+		require.ErrorIs(t, err, bucket.ErrConflict)
+		assert.Equal(t, 3, calls)
+	})
+}
+
+type putEntry struct {
+	key string
+	hdr peanats.Header
+	val *counter
+}
+
+func (e *putEntry) Key() string            { return e.key }
+func (e *putEntry) Header() peanats.Header { return e.hdr }
+func (e *putEntry) Value() *counter        { return e.val }
@@ -0,0 +1,365 @@
+package bucket
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/codec"
+)
+
+// ErrImportConflict is returned by Import when a key to import already exists
+// and the conflict mode is ConflictFail.
+var ErrImportConflict = errors.New("import conflict")
+
+// SnapshotRecord is a single revision of an entry in a snapshot.
+type SnapshotRecord struct {
+	Key string `json:"key"`
+	// Op is "delete" or "purge" for tombstones and empty otherwise.
+	Op     string         `json:"op,omitempty"`
+	Header peanats.Header `json:"header,omitempty"`
+	// Value holds the decoded value of JSON entries. Data holds the stored
+	// bytes of any other entry, as is.
+	Value json.RawMessage `json:"value,omitempty"`
+	Data  []byte          `json:"data,omitempty"`
+	// Revision and Created describe the exported entry; imported entries get
+	// new ones.
+	Revision uint64    `json:"revision"`
+	Created  time.Time `json:"created"`
+}
+
+const (
+	snapshotOpDelete = "delete"
+	snapshotOpPurge  = "purge"
+)
+
+// SnapshotFormat is the encoding of a snapshot.
+type SnapshotFormat uint8
+
+const (
+	// SnapshotJSONL writes a record per line.
+	SnapshotJSONL SnapshotFormat = iota
+	// SnapshotTar writes a record per file named <key>/<revision>.json.
+	SnapshotTar
+)
+
+type ExportOption func(*exportParams)
+
+type exportParams struct {
+	format  SnapshotFormat
+	prefix  string
+	history bool
+	deletes bool
+	raw     bool
+}
+
+// ExportFormat sets the format of the snapshot, SnapshotJSONL by default.
+func ExportFormat(f SnapshotFormat) ExportOption {
+	return func(p *exportParams) {
+		p.format = f
+	}
+}
+
+// ExportKeyPrefix limits the snapshot to the keys of a bucket created with
+// BucketKeyPrefix.
+func ExportKeyPrefix(prefix string) ExportOption {
+	return func(p *exportParams) {
+		p.prefix = prefix
+	}
+}
+
+// ExportHistory includes every revision kept by the bucket instead of the
+// latest one only.
+func ExportHistory() ExportOption {
+	return func(p *exportParams) {
+		p.history = true
+	}
+}
+
+// ExportDeletes includes tombstones of deleted and purged keys.
+func ExportDeletes() ExportOption {
+	return func(p *exportParams) {
+		p.deletes = true
+	}
+}
+
+// ExportRaw keeps the stored bytes of every entry instead of decoding JSON
+// values.
+func ExportRaw() ExportOption {
+	return func(p *exportParams) {
+		p.raw = true
+	}
+}
+
+// Export writes a snapshot of kv to w. Revisions are written in order, so
+// that importing them replays the history.
+func Export(ctx context.Context, kv jetstream.KeyValue, w io.Writer, opts ...ExportOption) error {
+	p := exportParams{}
+	for _, o := range opts {
+		o(&p)
+	}
+	filter := ">"
+	if p.prefix != "" {
+		filter = p.prefix + ".>"
+	}
+	var wopts []jetstream.WatchOpt
+	if p.history {
+		wopts = append(wopts, jetstream.IncludeHistory())
+	}
+	if !p.deletes {
+		wopts = append(wopts, jetstream.IgnoreDeletes())
+	}
+	watcher, err := kv.Watch(ctx, filter, wopts...)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = watcher.Stop() }()
+
+	var write func(SnapshotRecord) error
+	finish := func() error { return nil }
+	switch p.format {
+	case SnapshotJSONL:
+		enc := json.NewEncoder(w)
+		write = func(rec SnapshotRecord) error {
+			return enc.Encode(rec)
+		}
+	case SnapshotTar:
+		tw := tar.NewWriter(w)
+		finish = tw.Close
+		write = func(rec SnapshotRecord) error {
+			data, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			err = tw.WriteHeader(&tar.Header{
+				Name:    fmt.Sprintf("%s/%020d.json", rec.Key, rec.Revision),
+				Mode:    0o644,
+				Size:    int64(len(data)),
+				ModTime: rec.Created,
+			})
+			if err != nil {
+				return err
+			}
+			_, err = tw.Write(data)
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown snapshot format: %d", p.format)
+	}
+
+	for {
+		var raw jetstream.KeyValueEntry
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case raw = <-watcher.Updates():
+		}
+		if raw == nil {
+			break
+		}
+		if err := write(snapshotRecord(raw, p.raw)); err != nil {
+			return err
+		}
+	}
+	return finish()
+}
+
+func snapshotRecord(raw jetstream.KeyValueEntry, keepRaw bool) SnapshotRecord {
+	rec := SnapshotRecord{
+		Key:      raw.Key(),
+		Revision: raw.Revision(),
+		Created:  raw.Created(),
+	}
+	switch raw.Operation() {
+	case jetstream.KeyValueDelete:
+		rec.Op = snapshotOpDelete
+		return rec
+	case jetstream.KeyValuePurge:
+		rec.Op = snapshotOpPurge
+		return rec
+	}
+	h, data, err := decodeBucketEntryPayload(raw.Value())
+	switch {
+	case err != nil:
+		// not written through a typed bucket
+		rec.Data = raw.Value()
+	case !keepRaw && codec.TypeFromHeader(h) == codec.JSON && json.Valid(data):
+		rec.Header, rec.Value = h, data
+	default:
+		rec.Header, rec.Data = h, raw.Value()
+	}
+	return rec
+}
+
+// ConflictMode tells Import what to do with keys that already exist.
+type ConflictMode uint8
+
+const (
+	// ConflictFail stops the import with ErrImportConflict.
+	ConflictFail ConflictMode = iota
+	// ConflictSkip leaves existing keys as they are.
+	ConflictSkip
+	// ConflictOverwrite writes over existing keys.
+	ConflictOverwrite
+)
+
+type ImportOption func(*importParams)
+
+type importParams struct {
+	mode ConflictMode
+}
+
+// ImportOnConflict sets how existing keys are treated, ConflictFail by
+// default.
+func ImportOnConflict(m ConflictMode) ImportOption {
+	return func(p *importParams) {
+		p.mode = m
+	}
+}
+
+// Import writes the records of a snapshot made by Export to kv, in either
+// format. The revisions of a key are replayed in order. Unless the conflict
+// mode is ConflictOverwrite, a key counts as existing when it holds a value
+// at the time its first record is imported.
+func Import(ctx context.Context, kv jetstream.KeyValue, r io.Reader, opts ...ImportOption) error {
+	p := importParams{}
+	for _, o := range opts {
+		o(&p)
+	}
+	br := bufio.NewReader(r)
+	next := jsonlRecords(br)
+	if isTar(br) {
+		next = tarRecords(tar.NewReader(br))
+	}
+	// keys imported so far, and whether their records are applied
+	claimed := make(map[string]bool)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rec, err := next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		apply, seen := claimed[rec.Key]
+		if seen && !apply {
+			continue
+		}
+		if !seen && p.mode != ConflictOverwrite {
+			apply, err = claim(ctx, kv, rec, p.mode)
+			if err != nil {
+				return err
+			}
+			claimed[rec.Key] = apply
+			continue
+		}
+		claimed[rec.Key] = true
+		if err := importRecord(ctx, kv, rec); err != nil {
+			return fmt.Errorf("%s: %w", rec.Key, err)
+		}
+	}
+}
+
+// claim imports the first record of a key unless the key exists. It tells
+// whether the following records of the key are to be imported.
+func claim(ctx context.Context, kv jetstream.KeyValue, rec SnapshotRecord, mode ConflictMode) (bool, error) {
+	var err error
+	if rec.Op == "" {
+		var data []byte
+		data, err = recordData(rec)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", rec.Key, err)
+		}
+		_, err = kv.Create(ctx, rec.Key, data)
+	} else {
+		_, err = kv.Get(ctx, rec.Key)
+		switch {
+		case errors.Is(err, jetstream.ErrKeyNotFound):
+			err = importRecord(ctx, kv, rec)
+		case err == nil:
+			err = ErrKeyExists
+		}
+	}
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrKeyExists) && mode == ConflictSkip:
+		return false, nil
+	case errors.Is(err, ErrKeyExists):
+		return false, fmt.Errorf("%w: %s", ErrImportConflict, rec.Key)
+	default:
+		return false, fmt.Errorf("%s: %w", rec.Key, err)
+	}
+}
+
+func importRecord(ctx context.Context, kv jetstream.KeyValue, rec SnapshotRecord) error {
+	switch rec.Op {
+	case snapshotOpDelete:
+		return kv.Delete(ctx, rec.Key)
+	case snapshotOpPurge:
+		return kv.Purge(ctx, rec.Key)
+	case "":
+		data, err := recordData(rec)
+		if err != nil {
+			return err
+		}
+		_, err = kv.Put(ctx, rec.Key, data)
+		return err
+	default:
+		return fmt.Errorf("unknown operation: %s", rec.Op)
+	}
+}
+
+// recordData returns the bytes to store for a record, encoding JSON values
+// the way Put would.
+func recordData(rec SnapshotRecord) ([]byte, error) {
+	if rec.Value == nil {
+		return rec.Data, nil
+	}
+	h := rec.Header
+	if h == nil {
+		h = peanats.Header{}
+	}
+	return encodeBucketEntryHeader(h, &rec.Value)
+}
+
+func isTar(br *bufio.Reader) bool {
+	b, err := br.Peek(262)
+	return err == nil && string(b[257:262]) == "ustar"
+}
+
+func jsonlRecords(r io.Reader) func() (SnapshotRecord, error) {
+	dec := json.NewDecoder(r)
+	return func() (SnapshotRecord, error) {
+		var rec SnapshotRecord
+		err := dec.Decode(&rec)
+		return rec, err
+	}
+}
+
+func tarRecords(tr *tar.Reader) func() (SnapshotRecord, error) {
+	return func() (SnapshotRecord, error) {
+		var rec SnapshotRecord
+		for {
+			th, err := tr.Next()
+			if err != nil {
+				return rec, err
+			}
+			if th.Typeflag != tar.TypeReg {
+				continue
+			}
+			return rec, json.NewDecoder(tr).Decode(&rec)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package bucket_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/bucket"
+	"github.com/mikluko/peanats/codec"
+)
+
+func TestSnapshot(t *testing.T) {
+	src := setupKeyValue(t)
+	b := bucket.NewBucket[counter](src)
+	hdr := peanats.Header{"X-Seq": []string{"1"}}
+	codec.SetContentEncoding(hdr, codec.Zstd)
+	for i, key := range []string{"a", "b", "a"} {
+		_, err := b.Put(t.Context(), &putEntry{key: key, hdr: hdr, val: &counter{N: i}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, b.Delete(t.Context(), "b"))
+	_, err := src.PutString(t.Context(), "raw", "not an entry")
+	require.NoError(t, err)
+
+	history := func(t *testing.T, kv jetstream.KeyValue, key string) []jetstream.KeyValueOp {
+		hist, err := kv.History(t.Context(), key)
+		require.NoError(t, err)
+		var ops []jetstream.KeyValueOp
+		for _, e := range hist {
+			ops = append(ops, e.Operation())
+		}
+		return ops
+	}
+
+	for name, format := range map[string]bucket.SnapshotFormat{"jsonl": bucket.SnapshotJSONL, "tar": bucket.SnapshotTar} {
+		t.Run(name, func(t *testing.T) {
+			t.Run("latest", func(t *testing.T) {
+				var buf bytes.Buffer
+				require.NoError(t, bucket.Export(t.Context(), src, &buf, bucket.ExportFormat(format)))
+				kv := setupKeyValue(t)
+				require.NoError(t, bucket.Import(t.Context(), kv, &buf))
+
+				ent, err := bucket.NewBucket[counter](kv).Get(t.Context(), "a")
+				require.NoError(t, err)
+				assert.Equal(t, 2, ent.Value().N)
+				assert.Equal(t, "1", ent.Header().Get("X-Seq"))
+				assert.Equal(t, "zstd", ent.Header().Get(codec.HeaderContentEncoding))
+				assert.Len(t, history(t, kv, "a"), 1)
+				_, err = kv.Get(t.Context(), "b")
+				assert.True(t, errors.Is(err, jetstream.ErrKeyNotFound))
+				raw, err := kv.Get(t.Context(), "raw")
+				require.NoError(t, err)
+				assert.Equal(t, "not an entry", string(raw.Value()))
+			})
+			t.Run("history", func(t *testing.T) {
+				var buf bytes.Buffer
+				require.NoError(t, bucket.Export(t.Context(), src, &buf,
+					bucket.ExportFormat(format), bucket.ExportHistory(), bucket.ExportDeletes()))
+				kv := setupKeyValue(t)
+				require.NoError(t, bucket.Import(t.Context(), kv, &buf))
+
+				assert.Equal(t, []jetstream.KeyValueOp{jetstream.KeyValuePut, jetstream.KeyValuePut}, history(t, kv, "a"))
+				assert.Equal(t, []jetstream.KeyValueOp{jetstream.KeyValuePut, jetstream.KeyValueDelete}, history(t, kv, "b"))
+			})
+		})
+	}
+
+	t.Run("raw", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, bucket.Export(t.Context(), src, &buf, bucket.ExportRaw()))
+		assert.NotContains(t, buf.String(), `"value"`)
+		kv := setupKeyValue(t)
+		require.NoError(t, bucket.Import(t.Context(), kv, &buf))
+		want, err := src.Get(t.Context(), "a")
+		require.NoError(t, err)
+		got, err := kv.Get(t.Context(), "a")
+		require.NoError(t, err)
+		assert.Equal(t, want.Value(), got.Value())
+	})
+
+	t.Run("conflicts", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, bucket.Export(t.Context(), src, &buf, bucket.ExportHistory()))
+		snapshot := buf.Bytes()
+
+		kv := setupKeyValue(t)
+		_, err := bucket.NewBucket[counter](kv).Put(t.Context(), &putEntry{key: "a", hdr: peanats.Header{}, val: &counter{N: 100}})
+		require.NoError(t, err)
+		value := func() int {
+			ent, err := bucket.NewBucket[counter](kv).Get(t.Context(), "a")
+			require.NoError(t, err)
+			return ent.Value().N
+		}
+
+		err = bucket.Import(t.Context(), kv, bytes.NewReader(snapshot))
+		assert.True(t, errors.Is(err, bucket.ErrImportConflict))
+		assert.Equal(t, 100, value())
+
+		require.NoError(t, bucket.Import(t.Context(), kv, bytes.NewReader(snapshot), bucket.ImportOnConflict(bucket.ConflictSkip)))
+		assert.Equal(t, 100, value())
+		_, err = kv.Get(t.Context(), "raw")
+		require.NoError(t, err)
+
+		require.NoError(t, bucket.Import(t.Context(), kv, bytes.NewReader(snapshot), bucket.ImportOnConflict(bucket.ConflictOverwrite)))
+		assert.Equal(t, 2, value())
+	})
+}
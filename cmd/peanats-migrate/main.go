@@ -0,0 +1,137 @@
+// Command peanats-migrate re-encodes the entries of a key-value bucket with
+// another content type or content encoding.
+//
+// The values are decoded generically, so the command suits JSON, YAML and
+// msgpack entries. Numbers decoded from JSON are floats, which msgpack keeps
+// as such. Migrations changing the shape of values or involving protobuf need
+// bucket.Migrate with the Go types.
+//
+//	peanats-migrate -bucket settings -content-type msgpack -content-encoding zstd -checkpoint settings.ckpt
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/mikluko/peanats/bucket"
+	"github.com/mikluko/peanats/codec"
+)
+
+func main() {
+	var (
+		url         = flag.String("server", nats.DefaultURL, "NATS server URL")
+		name        = flag.String("bucket", "", "key-value bucket to migrate")
+		prefix      = flag.String("prefix", "", "migrate only the keys under this prefix")
+		contentType = flag.String("content-type", "", "target content type: json, yaml or msgpack; empty keeps it")
+		encoding    = flag.String("content-encoding", "", "target content encoding: zstd, s2 or none; empty keeps it")
+		checkpoint  = flag.String("checkpoint", "", "file keeping the progress, to resume an interrupted run")
+		every       = flag.Int("checkpoint-every", bucket.DefaultMigrateCheckpointEvery, "save the progress every n keys")
+	)
+	flag.Parse()
+	if err := run(*url, *name, *prefix, *contentType, *encoding, *checkpoint, *every); err != nil {
+		slog.Error("migration failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(url, name, prefix, contentType, encoding, checkpoint string, every int) error {
+	if name == "" {
+		return errors.New("bucket is required")
+	}
+	opts := []bucket.MigrateOption{
+		bucket.MigrateKeyPrefix(prefix),
+		bucket.MigrateOnProgress(func(p bucket.MigrateProgress) {
+			if p.Done%max(every, 1) == 0 || p.Done == p.Total {
+				slog.Info("progress", "key", p.Key, "done", p.Done, "total", p.Total, "migrated", p.Migrated)
+			}
+		}),
+	}
+	if contentType != "" {
+		c, err := parseContentType(contentType)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, bucket.MigrateContentType(c))
+	}
+	if encoding != "" {
+		e, err := parseContentEncoding(encoding)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, bucket.MigrateContentEncoding(e))
+	}
+	if checkpoint != "" {
+		opts = append(opts, bucket.MigrateWithCheckpoint(fileCheckpoint(checkpoint), every))
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	nc, err := nats.Connect(url)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return err
+	}
+	kv, err := js.KeyValue(ctx, name)
+	if err != nil {
+		return err
+	}
+	p, err := bucket.Migrate[any, any](ctx, kv, nil, opts...)
+	if err != nil {
+		return err
+	}
+	slog.Info("done", "total", p.Total, "migrated", p.Migrated)
+	return nil
+}
+
+func parseContentType(s string) (codec.ContentType, error) {
+	for _, c := range []codec.ContentType{codec.JSON, codec.YAML, codec.Msgpack} {
+		if s == c.String() || "application/"+s == c.String() {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported content type: %s", s)
+}
+
+func parseContentEncoding(s string) (codec.ContentEncoding, error) {
+	if s == "none" {
+		return 0, nil
+	}
+	for _, e := range []codec.ContentEncoding{codec.Zstd, codec.S2} {
+		if s == e.String() {
+			return e, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported content encoding: %s", s)
+}
+
+// fileCheckpoint keeps the last migrated key in a file.
+type fileCheckpoint string
+
+func (f fileCheckpoint) Load(context.Context) (string, error) {
+	b, err := os.ReadFile(string(f))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(b)), err
+}
+
+func (f fileCheckpoint) Save(_ context.Context, key string) error {
+	tmp := string(f) + ".tmp"
+	if err := os.WriteFile(tmp, []byte(key+"\n"), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, string(f))
+}
@@ -11,8 +11,11 @@ import (
 type ConsumeOption func(*consumeParams)
 
 type consumeParams struct {
-	disp peanats.Dispatcher
-	opts []jetstream.PullConsumeOpt
+	disp    peanats.Dispatcher
+	opts    []jetstream.PullConsumeOpt
+	obs     []Observer
+	ctl     Control
+	pullMax int
 }
 
 // ConsumeDispatcher sets the dispatcher.
@@ -33,6 +36,23 @@ func ConsumeJetstreamOption(opt jetstream.PullConsumeOpt) ConsumeOption {
 func ConsumePullMaxMessages(size int) ConsumeOption {
 	return func(p *consumeParams) {
 		p.opts = append(p.opts, jetstream.PullMaxMessages(size))
+		p.pullMax = size
+	}
+}
+
+// ConsumeObserver runs the observer alongside the consumer for as long as the
+// consume context is alive.
+func ConsumeObserver(o Observer) ConsumeOption {
+	return func(p *consumeParams) {
+		p.obs = append(p.obs, o)
+	}
+}
+
+// ConsumeControl attaches runtime controls to the consumer. A Control can be
+// attached to a single Consume call only.
+func ConsumeControl(ctl Control) ConsumeOption {
+	return func(p *consumeParams) {
+		p.ctl = ctl
 	}
 }
 
@@ -43,25 +63,35 @@ type consumer interface {
 // Consume implements consumer side of producer/consumer pattern.
 func Consume(ctx context.Context, c consumer, h peanats.MsgHandler, opts ...ConsumeOption) error {
 	p := consumeParams{
-		disp: peanats.DefaultDispatcher,
-		opts: []jetstream.PullConsumeOpt{},
+		disp:    peanats.DefaultDispatcher,
+		opts:    []jetstream.PullConsumeOpt{},
+		pullMax: jetstream.DefaultMaxMessages,
 	}
 	for _, o := range opts {
 		o(&p)
 	}
-	cc, err := c.Consume(func(m jetstream.Msg) {
+	ctl := p.ctl
+	if ctl == nil {
+		ctl = NewControl()
+	}
+	err := ctl.attach(ctx, c, func(m jetstream.Msg) {
 		p.disp.Dispatch(func() error {
 			ctx, cancel := context.WithCancel(ctx)
 			defer cancel()
 			return h.HandleMsg(ctx, peanats.NewJetstream(m))
 		})
-	}, p.opts...)
+	}, p.opts, p.pullMax)
 	if err != nil {
 		return err
 	}
+	for _, o := range p.obs {
+		go func() {
+			_ = o.Observe(ctx)
+		}()
+	}
 	go func() {
 		<-ctx.Done()
-		cc.Stop()
+		ctl.detach()
 	}()
 	return nil
 }
@@ -0,0 +1,230 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"golang.org/x/time/rate"
+
+	"github.com/mikluko/peanats"
+)
+
+// Control provides runtime controls over a running Consume call.
+type Control interface {
+	// Pause stops pulling new messages. The consumer itself is kept on the
+	// server, and messages already pulled are still delivered to the handler.
+	Pause() error
+	// Resume starts pulling again after Pause.
+	Resume() error
+	Paused() bool
+	// SetRate limits delivery to the handler to n messages per second and
+	// shrinks the pull batch accordingly. Zero removes the limit.
+	SetRate(n float64) error
+	Rate() float64
+
+	attach(context.Context, consumer, jetstream.MessageHandler, []jetstream.PullConsumeOpt, int) error
+	detach()
+}
+
+var (
+	ErrControlAttached = errors.New("control is already attached to a consumer")
+	ErrInvalidRate     = errors.New("rate must not be negative")
+)
+
+// NewControl creates a Control to be passed to Consume with ConsumeControl.
+// Calls made before Consume take effect when it starts.
+func NewControl() Control {
+	return &controlImpl{}
+}
+
+type controlImpl struct {
+	mu       sync.Mutex
+	paused   bool
+	rate     float64
+	limiter  *rate.Limiter
+	attached bool
+	detached bool
+
+	c       consumer
+	h       jetstream.MessageHandler
+	opts    []jetstream.PullConsumeOpt
+	pullMax int
+	cc      jetstream.ConsumeContext
+}
+
+func (c *controlImpl) attach(ctx context.Context, con consumer, h jetstream.MessageHandler, opts []jetstream.PullConsumeOpt, pullMax int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.attached {
+		return ErrControlAttached
+	}
+	c.attached = true
+	c.c, c.opts, c.pullMax = con, opts, pullMax
+	c.setLimiter()
+	c.h = func(m jetstream.Msg) {
+		c.mu.Lock()
+		lim := c.limiter
+		c.mu.Unlock()
+		if lim != nil {
+			if err := lim.Wait(ctx); err != nil {
+				return
+			}
+		}
+		h(m)
+	}
+	return c.start()
+}
+
+func (c *controlImpl) detach() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.detached = true
+	if c.cc != nil {
+		c.cc.Stop()
+		c.cc = nil
+	}
+}
+
+// start must be called with the lock held.
+func (c *controlImpl) start() error {
+	if !c.attached || c.detached || c.paused || c.cc != nil {
+		return nil
+	}
+	opts := c.opts
+	if c.rate > 0 {
+		opts = append(opts[:len(opts):len(opts)], jetstream.PullMaxMessages(c.batch()))
+	}
+	cc, err := c.c.Consume(c.h, opts...)
+	if err != nil {
+		return err
+	}
+	c.cc = cc
+	return nil
+}
+
+// stop must be called with the lock held.
+func (c *controlImpl) stop() {
+	if c.cc != nil {
+		c.cc.Drain()
+		c.cc = nil
+	}
+}
+
+// batch pulls roughly one second worth of messages at the configured rate.
+func (c *controlImpl) batch() int {
+	limit := c.pullMax
+	if limit <= 0 {
+		limit = jetstream.DefaultMaxMessages
+	}
+	return max(min(int(math.Ceil(c.rate)), limit), 1)
+}
+
+// setLimiter must be called with the lock held.
+func (c *controlImpl) setLimiter() {
+	if c.rate == 0 {
+		c.limiter = nil
+	} else {
+		c.limiter = rate.NewLimiter(rate.Limit(c.rate), c.batch())
+	}
+}
+
+func (c *controlImpl) Pause() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = true
+	c.stop()
+	return nil
+}
+
+func (c *controlImpl) Resume() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = false
+	return c.start()
+}
+
+func (c *controlImpl) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+func (c *controlImpl) SetRate(n float64) error {
+	if n < 0 || math.IsNaN(n) {
+		return fmt.Errorf("%w: %v", ErrInvalidRate, n)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rate = n
+	c.setLimiter()
+	// restart pulling so that the new batch size takes effect
+	if c.cc != nil {
+		c.stop()
+		return c.start()
+	}
+	return nil
+}
+
+func (c *controlImpl) Rate() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rate
+}
+
+// ControlAction is the operation requested through a control subject.
+type ControlAction string
+
+const (
+	ControlActionStatus ControlAction = "status"
+	ControlActionPause  ControlAction = "pause"
+	ControlActionResume ControlAction = "resume"
+	ControlActionRate   ControlAction = "rate"
+)
+
+// ControlCommand is the request accepted by ControlHandler.
+type ControlCommand struct {
+	Action ControlAction `json:"action" yaml:"action" msgpack:"action"`
+	Rate   float64       `json:"rate,omitempty" yaml:"rate,omitempty" msgpack:"rate,omitempty"`
+}
+
+// ControlState is the reply sent by ControlHandler after applying a command.
+type ControlState struct {
+	Paused bool    `json:"paused" yaml:"paused" msgpack:"paused"`
+	Rate   float64 `json:"rate" yaml:"rate" msgpack:"rate"`
+}
+
+var ErrUnknownControlAction = errors.New("unknown control action")
+
+// ControlHandler exposes the control on a subject. Subscribe it with any
+// transport subscription; commands are replied to with the resulting state.
+//
+//	sub, err := conn.SubscribeHandler(ctx, "svc.orders.control", consumer.ControlHandler(ctl))
+func ControlHandler(ctl Control) peanats.MsgHandler {
+	return peanats.MsgHandlerFromArgHandler(peanats.ArgHandlerFunc[ControlCommand](
+		func(ctx context.Context, arg peanats.Arg[ControlCommand]) error {
+			var err error
+			switch cmd := arg.Value(); cmd.Action {
+			case ControlActionStatus:
+			case ControlActionPause:
+				err = ctl.Pause()
+			case ControlActionResume:
+				err = ctl.Resume()
+			case ControlActionRate:
+				err = ctl.SetRate(cmd.Rate)
+			default:
+				err = fmt.Errorf("%w: %q", ErrUnknownControlAction, cmd.Action)
+			}
+			if err != nil {
+				return err
+			}
+			if r, ok := arg.(peanats.Respondable); ok {
+				return r.Respond(ctx, &ControlState{Paused: ctl.Paused(), Rate: ctl.Rate()})
+			}
+			return nil
+		},
+	))
+}
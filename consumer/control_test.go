@@ -0,0 +1,127 @@
+package consumer_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/codec"
+	"github.com/mikluko/peanats/consumer"
+	"github.com/mikluko/peanats/internal/xtestutil"
+	"github.com/mikluko/peanats/transport"
+)
+
+func setupControlTest(t *testing.T, n int) (*nats.Conn, jetstream.Consumer) {
+	js := xtestutil.JetStream(t, xtestutil.Server(t))
+	s := xtestutil.Stream(t, js, jetstream.StreamConfig{
+		Name:     "control",
+		Subjects: []string{"control.>"},
+	})
+	c, err := s.CreateConsumer(t.Context(), jetstream.ConsumerConfig{
+		Durable:   "control",
+		AckPolicy: jetstream.AckExplicitPolicy,
+	})
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		_, err := js.Publish(t.Context(), fmt.Sprintf("control.%d", i), []byte(`{}`))
+		require.NoError(t, err)
+	}
+	return js.Conn(), c
+}
+
+func countingHandler(n *atomic.Int64) peanats.MsgHandler {
+	return peanats.MsgHandlerFunc(func(ctx context.Context, m peanats.Msg) error {
+		n.Add(1)
+		return m.(peanats.Ackable).Ack(ctx)
+	})
+}
+
+func TestControl(t *testing.T) {
+	t.Run("pause and resume", func(t *testing.T) {
+		_, c := setupControlTest(t, 10)
+
+		ctl := consumer.NewControl()
+		require.NoError(t, ctl.Pause())
+
+		var n atomic.Int64
+		err := consumer.Consume(t.Context(), c, countingHandler(&n),
+			consumer.ConsumeDispatcher(peanats.NewDispatcher()),
+			consumer.ConsumeControl(ctl),
+		)
+		require.NoError(t, err)
+
+		time.Sleep(100 * time.Millisecond)
+		assert.Zero(t, n.Load())
+		assert.True(t, ctl.Paused())
+
+		require.NoError(t, ctl.Resume())
+		assert.Eventually(t, func() bool { return n.Load() == 10 }, 5*time.Second, 10*time.Millisecond)
+	})
+	t.Run("attached once", func(t *testing.T) {
+		_, c := setupControlTest(t, 0)
+		ctl := consumer.NewControl()
+		h := peanats.MsgHandlerFunc(func(context.Context, peanats.Msg) error { return nil })
+		require.NoError(t, consumer.Consume(t.Context(), c, h, consumer.ConsumeControl(ctl)))
+		require.ErrorIs(t, consumer.Consume(t.Context(), c, h, consumer.ConsumeControl(ctl)), consumer.ErrControlAttached)
+	})
+	t.Run("rate", func(t *testing.T) {
+		_, c := setupControlTest(t, 10)
+
+		ctl := consumer.NewControl()
+		require.ErrorIs(t, ctl.SetRate(-1), consumer.ErrInvalidRate)
+		require.NoError(t, ctl.SetRate(5))
+
+		var n atomic.Int64
+		start := time.Now()
+		err := consumer.Consume(t.Context(), c, countingHandler(&n),
+			consumer.ConsumeDispatcher(peanats.NewDispatcher()),
+			consumer.ConsumeControl(ctl),
+		)
+		require.NoError(t, err)
+		assert.Eventually(t, func() bool { return n.Load() == 10 }, 5*time.Second, 10*time.Millisecond)
+		// the first five pass as a burst, the remaining five take a second
+		assert.GreaterOrEqual(t, time.Since(start), 800*time.Millisecond)
+		assert.Equal(t, float64(5), ctl.Rate())
+
+		require.NoError(t, ctl.SetRate(0))
+		assert.Zero(t, ctl.Rate())
+	})
+	t.Run("control subject", func(t *testing.T) {
+		nc, c := setupControlTest(t, 0)
+		conn := transport.New(nc)
+
+		ctl := consumer.NewControl()
+		h := peanats.MsgHandlerFunc(func(context.Context, peanats.Msg) error { return nil })
+		require.NoError(t, consumer.Consume(t.Context(), c, h, consumer.ConsumeControl(ctl)))
+
+		sub, err := conn.SubscribeHandler(t.Context(), "admin.control", consumer.ControlHandler(ctl),
+			transport.SubscribeHandlerDispatcher(peanats.NewDispatcher()))
+		require.NoError(t, err)
+		defer sub.Unsubscribe()
+
+		request := func(cmd consumer.ControlCommand) consumer.ControlState {
+			h := peanats.Header{}
+			data, err := codec.MarshalHeader(cmd, h)
+			require.NoError(t, err)
+			rs, err := nc.RequestMsg(&nats.Msg{Subject: "admin.control", Header: nats.Header(h), Data: data}, time.Second)
+			require.NoError(t, err)
+			st := consumer.ControlState{}
+			require.NoError(t, codec.UnmarshalHeader(rs.Data, &st, peanats.Header(rs.Header)))
+			return st
+		}
+
+		assert.Equal(t, consumer.ControlState{Paused: true}, request(consumer.ControlCommand{Action: consumer.ControlActionPause}))
+		assert.True(t, ctl.Paused())
+		assert.Equal(t, consumer.ControlState{Paused: true, Rate: 5}, request(consumer.ControlCommand{Action: consumer.ControlActionRate, Rate: 5}))
+		assert.Equal(t, consumer.ControlState{Rate: 5}, request(consumer.ControlCommand{Action: consumer.ControlActionResume}))
+		assert.Equal(t, consumer.ControlState{Rate: 5}, request(consumer.ControlCommand{Action: consumer.ControlActionStatus}))
+	})
+}
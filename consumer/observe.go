@@ -0,0 +1,177 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Lag is a snapshot of consumer progress as reported by the server.
+type Lag struct {
+	Stream         string
+	Consumer       string
+	NumPending     uint64
+	NumAckPending  int
+	NumRedelivered int
+	LastActive     time.Time
+}
+
+// HealthStatus is the coarse state of an observed consumer.
+type HealthStatus uint8
+
+const (
+	// HealthUnknown means the consumer has not been observed yet.
+	HealthUnknown HealthStatus = iota
+	// HealthOK means the consumer is within the configured lag threshold.
+	HealthOK
+	// HealthDegraded means the consumer is behind by more than the threshold.
+	HealthDegraded
+	// HealthUnavailable means the consumer info could not be fetched.
+	HealthUnavailable
+)
+
+func (s HealthStatus) String() string {
+	switch s {
+	case HealthUnknown:
+		return "unknown"
+	case HealthOK:
+		return "ok"
+	case HealthDegraded:
+		return "degraded"
+	case HealthUnavailable:
+		return "unavailable"
+	default:
+		panic(fmt.Sprintf("unknown health status: %d", s))
+	}
+}
+
+// Health is the last observed state of a consumer.
+type Health struct {
+	Status  HealthStatus
+	Lag     Lag
+	Err     error
+	Updated time.Time
+}
+
+// Reporter receives every health observation, e.g. to export it as metrics.
+type Reporter interface {
+	ReportHealth(context.Context, Health)
+}
+
+// Observer periodically polls consumer info and keeps the latest health.
+type Observer interface {
+	// Observe polls until ctx is done. Failures to fetch consumer info do not
+	// stop it; they are reflected in the health status instead.
+	Observe(context.Context) error
+	Health() Health
+}
+
+type ObserverOption func(*observerParams)
+
+type observerParams struct {
+	interval  time.Duration
+	threshold uint64
+	reporters []Reporter
+}
+
+const DefaultObserverInterval = 10 * time.Second
+
+// ObserverInterval sets how often consumer info is polled. Non-positive
+// values leave DefaultObserverInterval in place.
+func ObserverInterval(d time.Duration) ObserverOption {
+	return func(p *observerParams) {
+		if d > 0 {
+			p.interval = d
+		}
+	}
+}
+
+// ObserverLagThreshold sets the number of pending messages above which the
+// consumer is reported as degraded. Zero disables the check.
+func ObserverLagThreshold(n uint64) ObserverOption {
+	return func(p *observerParams) {
+		p.threshold = n
+	}
+}
+
+// ObserverReporter adds a reporter to be called on every observation.
+func ObserverReporter(r Reporter) ObserverOption {
+	return func(p *observerParams) {
+		p.reporters = append(p.reporters, r)
+	}
+}
+
+type infoer interface {
+	Info(context.Context) (*jetstream.ConsumerInfo, error)
+}
+
+// NewObserver creates an Observer for the consumer.
+func NewObserver(c infoer, opts ...ObserverOption) Observer {
+	p := observerParams{
+		interval: DefaultObserverInterval,
+	}
+	for _, o := range opts {
+		o(&p)
+	}
+	o := &observerImpl{c: c, params: p}
+	o.health.Store(&Health{})
+	return o
+}
+
+type observerImpl struct {
+	c      infoer
+	params observerParams
+	health atomic.Pointer[Health]
+}
+
+func (o *observerImpl) Observe(ctx context.Context) error {
+	t := time.NewTicker(o.params.interval)
+	defer t.Stop()
+	for {
+		o.observe(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+func (o *observerImpl) observe(ctx context.Context) {
+	h := Health{Updated: time.Now()}
+	info, err := o.c.Info(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		h.Status = HealthUnavailable
+		h.Err = err
+		h.Lag = o.health.Load().Lag
+	} else {
+		h.Lag = Lag{
+			Stream:         info.Stream,
+			Consumer:       info.Name,
+			NumPending:     info.NumPending,
+			NumAckPending:  info.NumAckPending,
+			NumRedelivered: info.NumRedelivered,
+		}
+		if info.Delivered.Last != nil {
+			h.Lag.LastActive = *info.Delivered.Last
+		}
+		h.Status = HealthOK
+		if o.params.threshold > 0 && info.NumPending > o.params.threshold {
+			h.Status = HealthDegraded
+		}
+	}
+	o.health.Store(&h)
+	for _, r := range o.params.reporters {
+		r.ReportHealth(ctx, h)
+	}
+}
+
+func (o *observerImpl) Health() Health {
+	return *o.health.Load()
+}
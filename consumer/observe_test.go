@@ -0,0 +1,99 @@
+package consumer_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats/consumer"
+	"github.com/mikluko/peanats/internal/xmock/jetstreammock"
+)
+
+type reporterFunc func(context.Context, consumer.Health)
+
+func (f reporterFunc) ReportHealth(ctx context.Context, h consumer.Health) {
+	f(ctx, h)
+}
+
+func TestObserver(t *testing.T) {
+	last := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	info := func(pending uint64) *jetstream.ConsumerInfo {
+		return &jetstream.ConsumerInfo{
+			Stream:         "ORDERS",
+			Name:           "billing",
+			NumPending:     pending,
+			NumAckPending:  2,
+			NumRedelivered: 1,
+			Delivered:      jetstream.SequenceInfo{Last: &last},
+		}
+	}
+
+	t.Run("status transitions", func(t *testing.T) {
+		testErr := errors.New("parson had a dog")
+		c := jetstreammock.NewConsumer(t)
+		c.EXPECT().Info(mock.Anything).Return(info(5), nil).Once()
+		c.EXPECT().Info(mock.Anything).Return(info(50), nil).Once()
+		c.EXPECT().Info(mock.Anything).Return(nil, testErr).Once()
+		c.EXPECT().Info(mock.Anything).Return(info(0), nil).Maybe()
+
+		var (
+			mu  sync.Mutex
+			got []consumer.Health
+		)
+		ctx, cancel := context.WithCancel(t.Context())
+		o := consumer.NewObserver(c,
+			consumer.ObserverInterval(time.Millisecond),
+			consumer.ObserverLagThreshold(10),
+			consumer.ObserverReporter(reporterFunc(func(_ context.Context, h consumer.Health) {
+				mu.Lock()
+				defer mu.Unlock()
+				got = append(got, h)
+				if len(got) == 3 {
+					cancel()
+				}
+			})),
+		)
+		assert.Equal(t, consumer.HealthUnknown, o.Health().Status)
+		require.NoError(t, o.Observe(ctx))
+
+		mu.Lock()
+		defer mu.Unlock()
+		require.GreaterOrEqual(t, len(got), 3)
+
+		assert.Equal(t, consumer.HealthOK, got[0].Status)
+		assert.Equal(t, consumer.Lag{
+			Stream:         "ORDERS",
+			Consumer:       "billing",
+			NumPending:     5,
+			NumAckPending:  2,
+			NumRedelivered: 1,
+			LastActive:     last,
+		}, got[0].Lag)
+
+		assert.Equal(t, consumer.HealthDegraded, got[1].Status)
+		assert.Equal(t, uint64(50), got[1].Lag.NumPending)
+
+		assert.Equal(t, consumer.HealthUnavailable, got[2].Status)
+		assert.ErrorIs(t, got[2].Err, testErr)
+		assert.Equal(t, "billing", got[2].Lag.Consumer, "last known lag is retained")
+	})
+	t.Run("non-positive interval", func(t *testing.T) {
+		c := jetstreammock.NewConsumer(t)
+		c.EXPECT().Info(mock.Anything).Return(info(0), nil).Once()
+
+		ctx, cancel := context.WithCancel(t.Context())
+		o := consumer.NewObserver(c,
+			consumer.ObserverInterval(0),
+			consumer.ObserverReporter(reporterFunc(func(context.Context, consumer.Health) { cancel() })),
+		)
+		require.NoError(t, o.Observe(ctx))
+		assert.Equal(t, consumer.HealthOK, o.Health().Status)
+	})
+}
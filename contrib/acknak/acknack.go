@@ -47,10 +47,7 @@ const (
 )
 
 // DelayPolicy calculates the delay duration for NAK acknowledgements based on the attempt number.
-type DelayPolicy interface {
-	// Delay calculates the delay for a given attempt number (1-based).
-	Delay(attempt uint64) time.Duration
-}
+type DelayPolicy = peanats.DelayPolicy
 
 type params struct {
 	ackPolicy      AckPolicy
@@ -0,0 +1,20 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/mikluko/peanats/election"
+)
+
+// ElectionReporter creates an election.Reporter that logs leadership changes.
+func ElectionReporter(log Logger) election.Reporter {
+	return electionReporter{log}
+}
+
+type electionReporter struct {
+	log Logger
+}
+
+func (r electionReporter) ReportEvent(ctx context.Context, e election.Event) {
+	r.log.Log(ctx, e.Type.String(), "election", e.Election, "candidate", e.Candidate, "token", e.Token)
+}
@@ -0,0 +1,70 @@
+package prom
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mikluko/peanats/consumer"
+)
+
+// ConsumerReporter creates a consumer.Reporter that exports consumer lag and
+// health as gauges labelled by stream and consumer name. It accepts the same
+// namespace, subsystem and registerer options as Middleware.
+func ConsumerReporter(opts ...Option) consumer.Reporter {
+	p := params{
+		namespace:  "peanats",
+		subsystem:  "",
+		registerer: prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&p)
+	}
+	labels := []string{"stream", "consumer"}
+	gauge := func(name, help string) *prometheus.GaugeVec {
+		return promauto.With(p.registerer).NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: p.namespace,
+			Subsystem: p.subsystem,
+			Name:      name,
+			Help:      help,
+		}, labels)
+	}
+	return &consumerReporter{
+		pending:     gauge("consumer_num_pending", "Number of messages not yet delivered to the consumer"),
+		ackPending:  gauge("consumer_num_ack_pending", "Number of delivered messages awaiting acknowledgment"),
+		redelivered: gauge("consumer_num_redelivered", "Number of messages redelivered and not yet acknowledged"),
+		lastActive:  gauge("consumer_last_active_timestamp_seconds", "Time of the last delivery to the consumer"),
+		healthy:     gauge("consumer_healthy", "Whether the consumer is within its lag threshold (1) or not (0)"),
+	}
+}
+
+type consumerReporter struct {
+	pending     *prometheus.GaugeVec
+	ackPending  *prometheus.GaugeVec
+	redelivered *prometheus.GaugeVec
+	lastActive  *prometheus.GaugeVec
+	healthy     *prometheus.GaugeVec
+}
+
+func (r *consumerReporter) ReportHealth(_ context.Context, h consumer.Health) {
+	// nothing is known about the consumer until its info was fetched once
+	if h.Lag.Consumer == "" {
+		return
+	}
+	lv := []string{h.Lag.Stream, h.Lag.Consumer}
+	if h.Status == consumer.HealthOK {
+		r.healthy.WithLabelValues(lv...).Set(1)
+	} else {
+		r.healthy.WithLabelValues(lv...).Set(0)
+	}
+	if h.Status == consumer.HealthUnavailable {
+		return
+	}
+	r.pending.WithLabelValues(lv...).Set(float64(h.Lag.NumPending))
+	r.ackPending.WithLabelValues(lv...).Set(float64(h.Lag.NumAckPending))
+	r.redelivered.WithLabelValues(lv...).Set(float64(h.Lag.NumRedelivered))
+	if !h.Lag.LastActive.IsZero() {
+		r.lastActive.WithLabelValues(lv...).Set(float64(h.Lag.LastActive.UnixNano()) / 1e9)
+	}
+}
@@ -0,0 +1,75 @@
+package prom
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats/consumer"
+)
+
+func TestConsumerReporter(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	r := ConsumerReporter(MiddlewareRegisterer(reg), MiddlewareNamespace("test"))
+
+	// not observed yet, nothing to export
+	r.ReportHealth(t.Context(), consumer.Health{})
+	n, err := testutil.GatherAndCount(reg)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	r.ReportHealth(t.Context(), consumer.Health{
+		Status: consumer.HealthDegraded,
+		Lag: consumer.Lag{
+			Stream:         "ORDERS",
+			Consumer:       "billing",
+			NumPending:     42,
+			NumAckPending:  3,
+			NumRedelivered: 1,
+			LastActive:     time.Unix(1700000000, 0),
+		},
+	})
+	err = testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP test_consumer_healthy Whether the consumer is within its lag threshold (1) or not (0)
+# TYPE test_consumer_healthy gauge
+test_consumer_healthy{consumer="billing",stream="ORDERS"} 0
+# HELP test_consumer_last_active_timestamp_seconds Time of the last delivery to the consumer
+# TYPE test_consumer_last_active_timestamp_seconds gauge
+test_consumer_last_active_timestamp_seconds{consumer="billing",stream="ORDERS"} 1.7e+09
+# HELP test_consumer_num_ack_pending Number of delivered messages awaiting acknowledgment
+# TYPE test_consumer_num_ack_pending gauge
+test_consumer_num_ack_pending{consumer="billing",stream="ORDERS"} 3
+# HELP test_consumer_num_pending Number of messages not yet delivered to the consumer
+# TYPE test_consumer_num_pending gauge
+test_consumer_num_pending{consumer="billing",stream="ORDERS"} 42
+# HELP test_consumer_num_redelivered Number of messages redelivered and not yet acknowledged
+# TYPE test_consumer_num_redelivered gauge
+test_consumer_num_redelivered{consumer="billing",stream="ORDERS"} 1
+`))
+	require.NoError(t, err)
+
+	r.ReportHealth(t.Context(), consumer.Health{
+		Status: consumer.HealthOK,
+		Lag:    consumer.Lag{Stream: "ORDERS", Consumer: "billing", NumPending: 1},
+	})
+	r.ReportHealth(t.Context(), consumer.Health{
+		Status: consumer.HealthUnavailable,
+		Err:    errors.New("parson had a dog"),
+		Lag:    consumer.Lag{Stream: "ORDERS", Consumer: "billing", NumPending: 1},
+	})
+	err = testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP test_consumer_healthy Whether the consumer is within its lag threshold (1) or not (0)
+# TYPE test_consumer_healthy gauge
+test_consumer_healthy{consumer="billing",stream="ORDERS"} 0
+# HELP test_consumer_num_pending Number of messages not yet delivered to the consumer
+# TYPE test_consumer_num_pending gauge
+test_consumer_num_pending{consumer="billing",stream="ORDERS"} 1
+`), "test_consumer_healthy", "test_consumer_num_pending")
+	require.NoError(t, err)
+}
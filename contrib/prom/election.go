@@ -0,0 +1,53 @@
+package prom
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mikluko/peanats/election"
+)
+
+// ElectionReporter creates an election.Reporter that exports whether the
+// candidate leads and counts leadership changes, labelled by election and
+// candidate. It accepts the same namespace, subsystem and registerer options
+// as Middleware.
+func ElectionReporter(opts ...Option) election.Reporter {
+	p := params{
+		namespace:  "peanats",
+		subsystem:  "",
+		registerer: prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&p)
+	}
+	return &electionReporter{
+		leader: promauto.With(p.registerer).NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: p.namespace,
+			Subsystem: p.subsystem,
+			Name:      "election_leader",
+			Help:      "Whether the candidate is the leader (1) or not (0)",
+		}, []string{"election", "candidate"}),
+		events: promauto.With(p.registerer).NewCounterVec(prometheus.CounterOpts{
+			Namespace: p.namespace,
+			Subsystem: p.subsystem,
+			Name:      "election_events_total",
+			Help:      "Number of leadership changes of the candidate by type",
+		}, []string{"election", "candidate", "event"}),
+	}
+}
+
+type electionReporter struct {
+	leader *prometheus.GaugeVec
+	events *prometheus.CounterVec
+}
+
+func (r *electionReporter) ReportEvent(_ context.Context, e election.Event) {
+	if e.Type == election.EventElected {
+		r.leader.WithLabelValues(e.Election, e.Candidate).Set(1)
+	} else {
+		r.leader.WithLabelValues(e.Election, e.Candidate).Set(0)
+	}
+	r.events.WithLabelValues(e.Election, e.Candidate, e.Type.String()).Inc()
+}
@@ -0,0 +1,40 @@
+package prom
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats/election"
+)
+
+func TestElectionReporter(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	r := ElectionReporter(MiddlewareRegisterer(reg), MiddlewareNamespace("test"))
+
+	r.ReportEvent(t.Context(), election.Event{Type: election.EventElected, Election: "scheduler", Candidate: "alice"})
+	err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP test_election_events_total Number of leadership changes of the candidate by type
+# TYPE test_election_events_total counter
+test_election_events_total{candidate="alice",election="scheduler",event="elected"} 1
+# HELP test_election_leader Whether the candidate is the leader (1) or not (0)
+# TYPE test_election_leader gauge
+test_election_leader{candidate="alice",election="scheduler"} 1
+`))
+	require.NoError(t, err)
+
+	r.ReportEvent(t.Context(), election.Event{Type: election.EventLost, Election: "scheduler", Candidate: "alice"})
+	err = testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP test_election_events_total Number of leadership changes of the candidate by type
+# TYPE test_election_events_total counter
+test_election_events_total{candidate="alice",election="scheduler",event="elected"} 1
+test_election_events_total{candidate="alice",election="scheduler",event="lost"} 1
+# HELP test_election_leader Whether the candidate is the leader (1) or not (0)
+# TYPE test_election_leader gauge
+test_election_leader{candidate="alice",election="scheduler"} 0
+`))
+	require.NoError(t, err)
+}
@@ -181,6 +181,125 @@ func (r *tracingRequester[RQ, RS]) ResponseReceiver(ctx context.Context, subject
 	}, nil
 }
 
+// Gather gathers replies from multiple responders with trace context propagation
+func (r *tracingRequester[RQ, RS]) Gather(ctx context.Context, subject string, data *RQ, opts ...requester.GatherOption) (*requester.Gathered[RS], error) {
+	spanOpts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(r.attrs, attribute.String("nats.subject", subject))...),
+	}
+	ctx, span := r.tracer.Start(ctx, r.spanName, spanOpts...)
+	defer span.End()
+
+	// Inject trace context into the request headers
+	header := make(peanats.Header)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+
+	// Emit request event
+	if attrs := buildMessageEventAttrs(header, data, r.eventHeaders, r.eventData, r.truncateDataAt); len(attrs) > 0 {
+		span.AddEvent("nats.request", trace.WithAttributes(attrs...))
+	}
+
+	g, err := r.Requester.Gather(ctx, subject, data, append(opts, requester.GatherRequestOptions(requester.RequestHeader(header)))...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	// Emit an event per response
+	for _, resp := range g.Responses {
+		if attrs := buildMessageEventAttrs(resp.Header(), resp.Value(), r.eventHeaders, r.eventData, r.truncateDataAt); len(attrs) > 0 {
+			span.AddEvent("nats.response", trace.WithAttributes(attrs...))
+		}
+	}
+	for _, err := range g.Errors {
+		span.RecordError(err)
+	}
+	span.SetAttributes(
+		attribute.Int("nats.gather.responses", len(g.Responses)),
+		attribute.Int("nats.gather.errors", len(g.Errors)),
+		attribute.String("nats.gather.stop", g.Stop.String()),
+	)
+
+	return g, nil
+}
+
+// OpenSession opens a session with trace context propagation. The span lasts
+// until the session is closed.
+func (r *tracingRequester[RQ, RS]) OpenSession(ctx context.Context, subject string, opts ...requester.SessionOption) (requester.Session[RQ, RS], error) {
+	spanOpts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(r.attrs, attribute.String("nats.subject", subject))...),
+	}
+	ctx, span := r.tracer.Start(ctx, r.spanName, spanOpts...)
+
+	// Inject trace context into the handshake headers
+	header := make(peanats.Header)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+
+	s, err := r.Requester.OpenSession(ctx, subject, append(opts, requester.SessionRequestOptions(requester.RequestHeader(header)))...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil, err
+	}
+
+	return &tracingSession[RQ, RS]{
+		Session:        s,
+		span:           span,
+		eventHeaders:   r.eventHeaders,
+		eventData:      r.eventData,
+		truncateDataAt: r.truncateDataAt,
+	}, nil
+}
+
+type tracingSession[RQ, RS any] struct {
+	requester.Session[RQ, RS]
+	span           trace.Span
+	eventHeaders   bool
+	eventData      bool
+	truncateDataAt int
+}
+
+// Send sends the next message and emits a request event
+func (s *tracingSession[RQ, RS]) Send(ctx context.Context, data *RQ) error {
+	if err := s.Session.Send(ctx, data); err != nil {
+		s.span.RecordError(err)
+		return err
+	}
+	if attrs := buildMessageEventAttrs(nil, data, s.eventHeaders, s.eventData, s.truncateDataAt); len(attrs) > 0 {
+		s.span.AddEvent("nats.request", trace.WithAttributes(attrs...))
+	}
+	return nil
+}
+
+// Recv receives the next message and emits a response event
+func (s *tracingSession[RQ, RS]) Recv(ctx context.Context) (requester.Response[RS], error) {
+	resp, err := s.Session.Recv(ctx)
+	if err != nil {
+		if !errors.Is(err, requester.ErrOver) {
+			s.span.RecordError(err)
+		}
+		return nil, err
+	}
+	if attrs := buildMessageEventAttrs(resp.Header(), resp.Value(), s.eventHeaders, s.eventData, s.truncateDataAt); len(attrs) > 0 {
+		s.span.AddEvent("nats.response", trace.WithAttributes(attrs...))
+	}
+	return resp, nil
+}
+
+// Close closes the session and ends the trace span
+func (s *tracingSession[RQ, RS]) Close() error {
+	defer s.span.End()
+	err := s.Session.Close()
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
 type tracingResponseReceiver[T any] struct {
 	requester.ResponseReceiver[T]
 	span           trace.Span
@@ -622,3 +622,116 @@ func TestTracingRequester_NoEventsWithoutOptions(t *testing.T) {
 	assert.Len(t, spans, 1)
 	assert.Empty(t, spans[0].Events, "no events should be emitted without event options")
 }
+
+func TestTracingRequester_Gather(t *testing.T) {
+	// Setup
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	tracer := tp.Tracer("test")
+	mockReq := requestermock.NewRequester[testRequest, testResponse](t)
+	mockResp := requestermock.NewResponse[testResponse](t)
+
+	ctx := context.Background()
+	subject := "test.subject"
+	reqData := &testRequest{Message: "hello"}
+
+	mockResp.EXPECT().Header().Return(peanats.Header{}).Maybe()
+	mockResp.EXPECT().Value().Return(&testResponse{Reply: "world"}).Maybe()
+
+	mockReq.EXPECT().Gather(
+		mock.AnythingOfType("*context.valueCtx"),
+		subject,
+		reqData,
+		mock.AnythingOfType("[]requester.GatherOption"),
+	).Return(&requester.Gathered[testResponse]{
+		Responses: []requester.Response[testResponse]{mockResp, mockResp},
+		Errors:    []error{errors.New("bad reply")},
+		Stop:      requester.GatherStopIdle,
+	}, nil)
+
+	req := NewRequester(mockReq,
+		RequesterWithTracer[testRequest, testResponse](tracer),
+		RequesterWithSpanName[testRequest, testResponse]("test.gather"),
+	)
+
+	g, err := req.Gather(ctx, subject, reqData)
+	assert.NoError(t, err)
+	assert.Len(t, g.Responses, 2)
+
+	tp.ForceFlush(ctx)
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+
+	span := spans[0]
+	assert.Equal(t, "test.gather", span.Name)
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, attr := range span.Attributes {
+		attrs[attr.Key] = attr.Value
+	}
+	assert.Equal(t, int64(2), attrs["nats.gather.responses"].AsInt64())
+	assert.Equal(t, int64(1), attrs["nats.gather.errors"].AsInt64())
+	assert.Equal(t, "idle", attrs["nats.gather.stop"].AsString())
+}
+
+type fakeSession struct {
+	requester.Session[testRequest, testResponse]
+	closed bool
+}
+
+func (s *fakeSession) Send(context.Context, *testRequest) error { return nil }
+
+func (s *fakeSession) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestTracingRequester_OpenSession(t *testing.T) {
+	// Setup
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	tracer := tp.Tracer("test")
+	mockReq := requestermock.NewRequester[testRequest, testResponse](t)
+
+	ctx := context.Background()
+	subject := "test.subject"
+	fake := &fakeSession{}
+
+	mockReq.EXPECT().OpenSession(
+		mock.AnythingOfType("*context.valueCtx"),
+		subject,
+		mock.AnythingOfType("[]requester.SessionOption"),
+	).Return(fake, nil)
+
+	req := NewRequester(mockReq,
+		RequesterWithTracer[testRequest, testResponse](tracer),
+		RequesterWithSpanName[testRequest, testResponse]("test.session"),
+		RequesterWithEventData[testRequest, testResponse](0),
+	)
+
+	s, err := req.OpenSession(ctx, subject)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Send(ctx, &testRequest{Message: "hello"}))
+
+	tp.ForceFlush(ctx)
+	assert.Empty(t, exporter.GetSpans(), "span should last until the session is closed")
+
+	assert.NoError(t, s.Close())
+	assert.True(t, fake.closed)
+
+	tp.ForceFlush(ctx)
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "test.session", spans[0].Name)
+	assert.Len(t, spans[0].Events, 1)
+	assert.Equal(t, "nats.request", spans[0].Events[0].Name)
+}
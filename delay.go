@@ -0,0 +1,11 @@
+package peanats
+
+import "time"
+
+// DelayPolicy calculates the pause before the next attempt of an operation
+// that is retried: a redelivered message, a repeated request or a conflicting
+// update. The delay policies of the acknak package implement it.
+type DelayPolicy interface {
+	// Delay calculates the delay for a given attempt number (1-based).
+	Delay(attempt uint64) time.Duration
+}
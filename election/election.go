@@ -0,0 +1,310 @@
+// Package election implements leader election on top of the locks of the
+// lock package: the leader is the candidate holding the election lock.
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nuid"
+
+	"github.com/mikluko/peanats/bucket"
+	"github.com/mikluko/peanats/lock"
+)
+
+var (
+	// ErrNoLeader is returned by Leader when no candidate holds the election.
+	ErrNoLeader = errors.New("no leader")
+	// ErrLost is the cause of a leader context canceled because leadership
+	// was lost.
+	ErrLost = lock.ErrLost
+	// ErrResigned is the cause of a leader context canceled by Resign.
+	ErrResigned = errors.New("resigned")
+)
+
+// EventType tells what happened to a candidate.
+type EventType uint8
+
+const (
+	// EventElected means the candidate became the leader.
+	EventElected EventType = iota + 1
+	// EventResigned means the leader gave up leadership.
+	EventResigned
+	// EventLost means the leader failed to renew its term in time.
+	EventLost
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventElected:
+		return "elected"
+	case EventResigned:
+		return "resigned"
+	case EventLost:
+		return "lost"
+	default:
+		panic(fmt.Sprintf("unknown event type: %d", t))
+	}
+}
+
+// Event is a leadership change of a candidate.
+type Event struct {
+	Type      EventType
+	Election  string
+	Candidate string
+	// Token is the fencing token of the term.
+	Token uint64
+	Time  time.Time
+}
+
+// Reporter receives the leadership changes of the candidate, e.g. to export
+// them as metrics or log them.
+type Reporter interface {
+	ReportEvent(context.Context, Event)
+}
+
+// Term is the leadership of the candidate.
+type Term interface {
+	// Token returns the fencing token of the term. Tokens grow with every
+	// term of the election.
+	Token() uint64
+	// Context is done when the term ends. context.Cause reports ErrLost or
+	// ErrResigned.
+	Context() context.Context
+	// Resign ends the term, letting another candidate be elected.
+	Resign(context.Context) error
+}
+
+// Election is a candidate in the election of a single leader among instances.
+type Election interface {
+	// Campaign waits until the candidate is elected.
+	Campaign(context.Context) (Term, error)
+	// Leader returns the identity of the current leader.
+	Leader(context.Context) (string, error)
+	// Observe sends the identity of the leader on every change, starting with
+	// the current one. An empty identity means there is no leader. A leader
+	// that went away without resigning is reported until it is replaced. The
+	// channel is closed when ctx is done.
+	Observe(context.Context) (<-chan string, error)
+	// Run campaigns and calls fn once elected. The context passed to fn is
+	// canceled when leadership is lost, after which Run campaigns again. Run
+	// resigns and returns the result of fn when fn returns while still the
+	// leader, and returns ctx.Err() when ctx is done.
+	Run(ctx context.Context, fn func(context.Context) error) error
+}
+
+type ElectionOption func(*electionParams)
+
+type electionParams struct {
+	candidate string
+	ttl       time.Duration
+	prefix    string
+	reporters []Reporter
+}
+
+const DefaultElectionTTL = 10 * time.Second
+
+// ElectionCandidate sets the identity of the candidate. By default a random
+// one is generated.
+func ElectionCandidate(id string) ElectionOption {
+	return func(p *electionParams) {
+		p.candidate = id
+	}
+}
+
+// ElectionTTL sets how long a term lasts without being renewed. The leader
+// renews it every third of the ttl; a crashed leader is replaced after the
+// ttl passes.
+func ElectionTTL(d time.Duration) ElectionOption {
+	return func(p *electionParams) {
+		p.ttl = d
+	}
+}
+
+// ElectionKeyPrefix keeps the election lock under the given key prefix.
+func ElectionKeyPrefix(prefix string) ElectionOption {
+	return func(p *electionParams) {
+		p.prefix = prefix
+	}
+}
+
+// ElectionReporter adds a reporter to be called on leadership changes.
+func ElectionReporter(r Reporter) ElectionOption {
+	return func(p *electionParams) {
+		p.reporters = append(p.reporters, r)
+	}
+}
+
+// NewElection creates a candidate in the election called name, kept in kv.
+func NewElection(kv jetstream.KeyValue, name string, opts ...ElectionOption) Election {
+	p := electionParams{
+		candidate: nuid.Next(),
+		ttl:       DefaultElectionTTL,
+	}
+	for _, o := range opts {
+		o(&p)
+	}
+	return &electionImpl{
+		name:   name,
+		params: p,
+		locker: lock.NewLocker(kv, lock.LockerHolder(p.candidate), lock.LockerKeyPrefix(p.prefix)),
+		bucket: bucket.NewBucket[lock.Holder](kv, bucket.BucketKeyPrefix(p.prefix)),
+	}
+}
+
+type electionImpl struct {
+	name   string
+	params electionParams
+	locker lock.Locker
+	bucket bucket.Bucket[lock.Holder]
+}
+
+func (e *electionImpl) report(ctx context.Context, t EventType, token uint64) {
+	ev := Event{
+		Type:      t,
+		Election:  e.name,
+		Candidate: e.params.candidate,
+		Token:     token,
+		Time:      time.Now(),
+	}
+	for _, r := range e.params.reporters {
+		r.ReportEvent(ctx, ev)
+	}
+}
+
+func (e *electionImpl) Campaign(ctx context.Context) (Term, error) {
+	lease, err := e.locker.Acquire(ctx, e.name, e.params.ttl)
+	if err != nil {
+		return nil, err
+	}
+	t := &termImpl{election: e, lease: lease}
+	t.ctx, t.cancel = context.WithCancelCause(context.Background())
+	context.AfterFunc(lease.Context(), func() {
+		cause := context.Cause(lease.Context())
+		if errors.Is(cause, lock.ErrLost) {
+			e.report(context.Background(), EventLost, lease.Token())
+			t.cancel(ErrLost)
+			return
+		}
+		t.cancel(ErrResigned)
+	})
+	e.report(ctx, EventElected, lease.Token())
+	return t, nil
+}
+
+func (e *electionImpl) Leader(ctx context.Context) (string, error) {
+	ent, err := e.bucket.Get(ctx, e.name)
+	if errors.Is(err, bucket.ErrKeyNotFound) {
+		return "", ErrNoLeader
+	}
+	if err != nil {
+		return "", err
+	}
+	if !time.Now().Before(ent.Value().Expires) {
+		return "", ErrNoLeader
+	}
+	return ent.Value().ID, nil
+}
+
+func (e *electionImpl) Observe(ctx context.Context) (<-chan string, error) {
+	// canceling the watch context while the watcher is being created races
+	// within nats.go, so the watch is tied to ctx only once it is set up
+	wctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	w, err := e.bucket.Watch(wctx, e.name)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	context.AfterFunc(ctx, cancel)
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		defer cancel()
+		defer func() { _ = w.Stop() }()
+		last, first := "", true
+		for {
+			ent, err := w.Next()
+			switch {
+			case errors.Is(err, bucket.ErrInitialValuesOver):
+				if !first {
+					continue
+				}
+			case errors.Is(err, bucket.ErrDone):
+				return
+			case err != nil:
+				continue
+			}
+			leader := ""
+			if ent != nil && ent.Operation() == jetstream.KeyValuePut {
+				leader = ent.Value().ID
+			}
+			if leader == last && !first {
+				continue
+			}
+			last, first = leader, false
+			select {
+			case ch <- leader:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (e *electionImpl) Run(ctx context.Context, fn func(context.Context) error) error {
+	for {
+		t, err := e.Campaign(ctx)
+		if err != nil {
+			return err
+		}
+		lctx, cancel := context.WithCancelCause(ctx)
+		stop := context.AfterFunc(t.Context(), func() { cancel(context.Cause(t.Context())) })
+		err = fn(lctx)
+		stop()
+		cancel(nil)
+		if errors.Is(context.Cause(t.Context()), ErrLost) {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+		// the term may outlive ctx, so resign with a fresh context
+		rerr := t.Resign(context.WithoutCancel(ctx))
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return errors.Join(err, rerr)
+	}
+}
+
+type termImpl struct {
+	election *electionImpl
+	lease    lock.Lease
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+	once     sync.Once
+}
+
+func (t *termImpl) Token() uint64 {
+	return t.lease.Token()
+}
+
+func (t *termImpl) Context() context.Context {
+	return t.ctx
+}
+
+func (t *termImpl) Resign(ctx context.Context) error {
+	err := t.lease.Release(ctx)
+	if errors.Is(err, lock.ErrLost) {
+		return ErrLost
+	}
+	t.once.Do(func() {
+		t.election.report(ctx, EventResigned, t.lease.Token())
+	})
+	return err
+}
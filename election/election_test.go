@@ -0,0 +1,184 @@
+package election_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats/election"
+	"github.com/mikluko/peanats/internal/xtestutil"
+)
+
+func setupKeyValue(t *testing.T) jetstream.KeyValue {
+	js := xtestutil.JetStream(t, xtestutil.Server(t))
+	return xtestutil.KeyValue(t, js, jetstream.KeyValueConfig{Bucket: "elections"})
+}
+
+type recorder struct {
+	mu     sync.Mutex
+	events []election.EventType
+}
+
+func (r *recorder) ReportEvent(_ context.Context, e election.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e.Type)
+}
+
+func (r *recorder) Events() []election.EventType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]election.EventType(nil), r.events...)
+}
+
+func TestElection(t *testing.T) {
+	kv := setupKeyValue(t)
+	rec := &recorder{}
+	alice := election.NewElection(kv, "scheduler", election.ElectionCandidate("alice"), election.ElectionReporter(rec))
+	bob := election.NewElection(kv, "scheduler", election.ElectionCandidate("bob"))
+
+	_, err := alice.Leader(t.Context())
+	assert.True(t, errors.Is(err, election.ErrNoLeader))
+
+	observed, err := bob.Observe(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "", <-observed)
+
+	term, err := alice.Campaign(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "alice", <-observed)
+	leader, err := bob.Leader(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "alice", leader)
+
+	elected := make(chan election.Term)
+	go func() {
+		term, err := bob.Campaign(t.Context())
+		assert.NoError(t, err)
+		elected <- term
+	}()
+	select {
+	case <-elected:
+		t.Fatal("two leaders")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	require.NoError(t, term.Resign(t.Context()))
+	assert.True(t, errors.Is(context.Cause(term.Context()), election.ErrResigned))
+	next := <-elected
+	assert.Greater(t, next.Token(), term.Token())
+	assert.Equal(t, "", <-observed)
+	assert.Equal(t, "bob", <-observed)
+	require.NoError(t, next.Resign(t.Context()))
+
+	assert.Equal(t, []election.EventType{election.EventElected, election.EventResigned}, rec.Events())
+}
+
+// stallingKeyValue hangs updates until their context is done once stall is
+// set, as with a server that stopped answering.
+type stallingKeyValue struct {
+	jetstream.KeyValue
+	stall atomic.Bool
+}
+
+func (kv *stallingKeyValue) Update(ctx context.Context, key string, value []byte, rev uint64) (uint64, error) {
+	if kv.stall.Load() {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	return kv.KeyValue.Update(ctx, key, value, rev)
+}
+
+func TestElection_Run(t *testing.T) {
+	kv := setupKeyValue(t)
+
+	t.Run("done", func(t *testing.T) {
+		e := election.NewElection(kv, "done")
+		boom := errors.New("boom")
+		err := e.Run(t.Context(), func(ctx context.Context) error {
+			return boom
+		})
+		assert.True(t, errors.Is(err, boom))
+		_, err = e.Leader(t.Context())
+		assert.True(t, errors.Is(err, election.ErrNoLeader))
+	})
+	t.Run("lost", func(t *testing.T) {
+		rec := &recorder{}
+		e := election.NewElection(kv, "lost", election.ElectionTTL(300*time.Millisecond), election.ElectionReporter(rec))
+		var terms int
+		err := e.Run(t.Context(), func(ctx context.Context) error {
+			terms++
+			if terms == 1 {
+				// somebody else takes over
+				_, err := kv.Put(t.Context(), "lost", []byte("{}"))
+				require.NoError(t, err)
+				<-ctx.Done()
+				assert.True(t, errors.Is(context.Cause(ctx), election.ErrLost))
+				require.NoError(t, kv.Delete(t.Context(), "lost"))
+				return ctx.Err()
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, terms)
+		assert.Eventually(t, func() bool {
+			return len(rec.Events()) == 4
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, []election.EventType{
+			election.EventElected, election.EventLost, election.EventElected, election.EventResigned,
+		}, rec.Events())
+	})
+	t.Run("stalled renewal", func(t *testing.T) {
+		stalling := &stallingKeyValue{KeyValue: kv}
+		a := election.NewElection(stalling, "stalled", election.ElectionCandidate("a"), election.ElectionTTL(300*time.Millisecond))
+		b := election.NewElection(kv, "stalled", election.ElectionCandidate("b"), election.ElectionTTL(time.Minute))
+
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+		leading := make(chan context.Context)
+		errc := make(chan error, 1)
+		go func() {
+			errc <- a.Run(ctx, func(ctx context.Context) error {
+				select {
+				case leading <- ctx:
+				case <-ctx.Done():
+				}
+				<-ctx.Done()
+				return nil
+			})
+		}()
+		leaderCtx := <-leading
+		stalling.stall.Store(true)
+
+		term, err := b.Campaign(t.Context())
+		require.NoError(t, err)
+		select {
+		case <-leaderCtx.Done():
+		default:
+			t.Fatal("previous leader still running")
+		}
+		assert.True(t, errors.Is(context.Cause(leaderCtx), election.ErrLost))
+		require.NoError(t, term.Resign(t.Context()))
+		cancel()
+		assert.True(t, errors.Is(<-errc, context.Canceled))
+	})
+	t.Run("canceled", func(t *testing.T) {
+		e := election.NewElection(kv, "canceled")
+		ctx, cancel := context.WithCancel(t.Context())
+		err := e.Run(ctx, func(ctx context.Context) error {
+			cancel()
+			<-ctx.Done()
+			return nil
+		})
+		assert.True(t, errors.Is(err, context.Canceled))
+		_, err = e.Leader(t.Context())
+		assert.True(t, errors.Is(err, election.ErrNoLeader))
+	})
+}
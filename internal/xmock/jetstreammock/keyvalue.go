@@ -83,8 +83,14 @@ func (_c *KeyValue_Bucket_Call) RunAndReturn(run func() string) *KeyValue_Bucket
 }
 
 // Create provides a mock function for the type KeyValue
-func (_mock *KeyValue) Create(ctx context.Context, key string, value []byte) (uint64, error) {
-	ret := _mock.Called(ctx, key, value)
+func (_mock *KeyValue) Create(ctx context.Context, key string, value []byte, opts ...jetstream.KVCreateOpt) (uint64, error) {
+	var tmpRet mock.Arguments
+	if len(opts) > 0 {
+		tmpRet = _mock.Called(ctx, key, value, opts)
+	} else {
+		tmpRet = _mock.Called(ctx, key, value)
+	}
+	ret := tmpRet
 
 	if len(ret) == 0 {
 		panic("no return value specified for Create")
@@ -92,16 +98,16 @@ func (_mock *KeyValue) Create(ctx context.Context, key string, value []byte) (ui
 
 	var r0 uint64
 	var r1 error
-	if returnFunc, ok := ret.Get(0).(func(context.Context, string, []byte) (uint64, error)); ok {
-		return returnFunc(ctx, key, value)
+	if returnFunc, ok := ret.Get(0).(func(context.Context, string, []byte, ...jetstream.KVCreateOpt) (uint64, error)); ok {
+		return returnFunc(ctx, key, value, opts...)
 	}
-	if returnFunc, ok := ret.Get(0).(func(context.Context, string, []byte) uint64); ok {
-		r0 = returnFunc(ctx, key, value)
+	if returnFunc, ok := ret.Get(0).(func(context.Context, string, []byte, ...jetstream.KVCreateOpt) uint64); ok {
+		r0 = returnFunc(ctx, key, value, opts...)
 	} else {
 		r0 = ret.Get(0).(uint64)
 	}
-	if returnFunc, ok := ret.Get(1).(func(context.Context, string, []byte) error); ok {
-		r1 = returnFunc(ctx, key, value)
+	if returnFunc, ok := ret.Get(1).(func(context.Context, string, []byte, ...jetstream.KVCreateOpt) error); ok {
+		r1 = returnFunc(ctx, key, value, opts...)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -117,11 +123,13 @@ type KeyValue_Create_Call struct {
 //   - ctx context.Context
 //   - key string
 //   - value []byte
-func (_e *KeyValue_Expecter) Create(ctx interface{}, key interface{}, value interface{}) *KeyValue_Create_Call {
-	return &KeyValue_Create_Call{Call: _e.mock.On("Create", ctx, key, value)}
+//   - opts ...jetstream.KVCreateOpt
+func (_e *KeyValue_Expecter) Create(ctx interface{}, key interface{}, value interface{}, opts ...interface{}) *KeyValue_Create_Call {
+	return &KeyValue_Create_Call{Call: _e.mock.On("Create",
+		append([]interface{}{ctx, key, value}, opts...)...)}
 }
 
-func (_c *KeyValue_Create_Call) Run(run func(ctx context.Context, key string, value []byte)) *KeyValue_Create_Call {
+func (_c *KeyValue_Create_Call) Run(run func(ctx context.Context, key string, value []byte, opts ...jetstream.KVCreateOpt)) *KeyValue_Create_Call {
 	_c.Call.Run(func(args mock.Arguments) {
 		var arg0 context.Context
 		if args[0] != nil {
@@ -135,10 +143,17 @@ func (_c *KeyValue_Create_Call) Run(run func(ctx context.Context, key string, va
 		if args[2] != nil {
 			arg2 = args[2].([]byte)
 		}
+		var arg3 []jetstream.KVCreateOpt
+		var variadicArgs []jetstream.KVCreateOpt
+		if len(args) > 3 {
+			variadicArgs = args[3].([]jetstream.KVCreateOpt)
+		}
+		arg3 = variadicArgs
 		run(
 			arg0,
 			arg1,
 			arg2,
+			arg3...,
 		)
 	})
 	return _c
@@ -149,7 +164,7 @@ func (_c *KeyValue_Create_Call) Return(v uint64, err error) *KeyValue_Create_Cal
 	return _c
 }
 
-func (_c *KeyValue_Create_Call) RunAndReturn(run func(ctx context.Context, key string, value []byte) (uint64, error)) *KeyValue_Create_Call {
+func (_c *KeyValue_Create_Call) RunAndReturn(run func(ctx context.Context, key string, value []byte, opts ...jetstream.KVCreateOpt) (uint64, error)) *KeyValue_Create_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -38,6 +38,178 @@ func (_m *Requester[RQ, RS]) EXPECT() *Requester_Expecter[RQ, RS] {
 	return &Requester_Expecter[RQ, RS]{mock: &_m.Mock}
 }
 
+// Gather provides a mock function for the type Requester
+func (_mock *Requester[RQ, RS]) Gather(context1 context.Context, s string, v *RQ, gatherOptions ...requester.GatherOption) (*requester.Gathered[RS], error) {
+	var tmpRet mock.Arguments
+	if len(gatherOptions) > 0 {
+		tmpRet = _mock.Called(context1, s, v, gatherOptions)
+	} else {
+		tmpRet = _mock.Called(context1, s, v)
+	}
+	ret := tmpRet
+
+	if len(ret) == 0 {
+		panic("no return value specified for Gather")
+	}
+
+	var r0 *requester.Gathered[RS]
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(context.Context, string, *RQ, ...requester.GatherOption) (*requester.Gathered[RS], error)); ok {
+		return returnFunc(context1, s, v, gatherOptions...)
+	}
+	if returnFunc, ok := ret.Get(0).(func(context.Context, string, *RQ, ...requester.GatherOption) *requester.Gathered[RS]); ok {
+		r0 = returnFunc(context1, s, v, gatherOptions...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*requester.Gathered[RS])
+		}
+	}
+	if returnFunc, ok := ret.Get(1).(func(context.Context, string, *RQ, ...requester.GatherOption) error); ok {
+		r1 = returnFunc(context1, s, v, gatherOptions...)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
+}
+
+// Requester_Gather_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Gather'
+type Requester_Gather_Call[RQ any, RS any] struct {
+	*mock.Call
+}
+
+// Gather is a helper method to define mock.On call
+//   - context1 context.Context
+//   - s string
+//   - v *RQ
+//   - gatherOptions ...requester.GatherOption
+func (_e *Requester_Expecter[RQ, RS]) Gather(context1 interface{}, s interface{}, v interface{}, gatherOptions ...interface{}) *Requester_Gather_Call[RQ, RS] {
+	return &Requester_Gather_Call[RQ, RS]{Call: _e.mock.On("Gather",
+		append([]interface{}{context1, s, v}, gatherOptions...)...)}
+}
+
+func (_c *Requester_Gather_Call[RQ, RS]) Run(run func(context1 context.Context, s string, v *RQ, gatherOptions ...requester.GatherOption)) *Requester_Gather_Call[RQ, RS] {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 context.Context
+		if args[0] != nil {
+			arg0 = args[0].(context.Context)
+		}
+		var arg1 string
+		if args[1] != nil {
+			arg1 = args[1].(string)
+		}
+		var arg2 *RQ
+		if args[2] != nil {
+			arg2 = args[2].(*RQ)
+		}
+		var arg3 []requester.GatherOption
+		var variadicArgs []requester.GatherOption
+		if len(args) > 3 {
+			variadicArgs = args[3].([]requester.GatherOption)
+		}
+		arg3 = variadicArgs
+		run(
+			arg0,
+			arg1,
+			arg2,
+			arg3...,
+		)
+	})
+	return _c
+}
+
+func (_c *Requester_Gather_Call[RQ, RS]) Return(gathered *requester.Gathered[RS], err error) *Requester_Gather_Call[RQ, RS] {
+	_c.Call.Return(gathered, err)
+	return _c
+}
+
+func (_c *Requester_Gather_Call[RQ, RS]) RunAndReturn(run func(context1 context.Context, s string, v *RQ, gatherOptions ...requester.GatherOption) (*requester.Gathered[RS], error)) *Requester_Gather_Call[RQ, RS] {
+	_c.Call.Return(run)
+	return _c
+}
+
+// OpenSession provides a mock function for the type Requester
+func (_mock *Requester[RQ, RS]) OpenSession(context1 context.Context, s string, sessionOptions ...requester.SessionOption) (requester.Session[RQ, RS], error) {
+	var tmpRet mock.Arguments
+	if len(sessionOptions) > 0 {
+		tmpRet = _mock.Called(context1, s, sessionOptions)
+	} else {
+		tmpRet = _mock.Called(context1, s)
+	}
+	ret := tmpRet
+
+	if len(ret) == 0 {
+		panic("no return value specified for OpenSession")
+	}
+
+	var r0 requester.Session[RQ, RS]
+	var r1 error
+	if returnFunc, ok := ret.Get(0).(func(context.Context, string, ...requester.SessionOption) (requester.Session[RQ, RS], error)); ok {
+		return returnFunc(context1, s, sessionOptions...)
+	}
+	if returnFunc, ok := ret.Get(0).(func(context.Context, string, ...requester.SessionOption) requester.Session[RQ, RS]); ok {
+		r0 = returnFunc(context1, s, sessionOptions...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(requester.Session[RQ, RS])
+		}
+	}
+	if returnFunc, ok := ret.Get(1).(func(context.Context, string, ...requester.SessionOption) error); ok {
+		r1 = returnFunc(context1, s, sessionOptions...)
+	} else {
+		r1 = ret.Error(1)
+	}
+	return r0, r1
+}
+
+// Requester_OpenSession_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'OpenSession'
+type Requester_OpenSession_Call[RQ any, RS any] struct {
+	*mock.Call
+}
+
+// OpenSession is a helper method to define mock.On call
+//   - context1 context.Context
+//   - s string
+//   - sessionOptions ...requester.SessionOption
+func (_e *Requester_Expecter[RQ, RS]) OpenSession(context1 interface{}, s interface{}, sessionOptions ...interface{}) *Requester_OpenSession_Call[RQ, RS] {
+	return &Requester_OpenSession_Call[RQ, RS]{Call: _e.mock.On("OpenSession",
+		append([]interface{}{context1, s}, sessionOptions...)...)}
+}
+
+func (_c *Requester_OpenSession_Call[RQ, RS]) Run(run func(context1 context.Context, s string, sessionOptions ...requester.SessionOption)) *Requester_OpenSession_Call[RQ, RS] {
+	_c.Call.Run(func(args mock.Arguments) {
+		var arg0 context.Context
+		if args[0] != nil {
+			arg0 = args[0].(context.Context)
+		}
+		var arg1 string
+		if args[1] != nil {
+			arg1 = args[1].(string)
+		}
+		var arg2 []requester.SessionOption
+		var variadicArgs []requester.SessionOption
+		if len(args) > 2 {
+			variadicArgs = args[2].([]requester.SessionOption)
+		}
+		arg2 = variadicArgs
+		run(
+			arg0,
+			arg1,
+			arg2...,
+		)
+	})
+	return _c
+}
+
+func (_c *Requester_OpenSession_Call[RQ, RS]) Return(session requester.Session[RQ, RS], err error) *Requester_OpenSession_Call[RQ, RS] {
+	_c.Call.Return(session, err)
+	return _c
+}
+
+func (_c *Requester_OpenSession_Call[RQ, RS]) RunAndReturn(run func(context1 context.Context, s string, sessionOptions ...requester.SessionOption) (requester.Session[RQ, RS], error)) *Requester_OpenSession_Call[RQ, RS] {
+	_c.Call.Return(run)
+	return _c
+}
+
 // Request provides a mock function for the type Requester
 func (_mock *Requester[RQ, RS]) Request(context1 context.Context, s string, v *RQ, requestOptions ...requester.RequestOption) (requester.Response[RS], error) {
 	var tmpRet mock.Arguments
@@ -0,0 +1,313 @@
+package xstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/codec"
+	"github.com/mikluko/peanats/transport"
+)
+
+// Params configures a session end.
+type Params struct {
+	// Window is the number of messages the peer may send ahead of Recv.
+	Window          uint
+	IdleTimeout     time.Duration
+	ReorderWindow   uint
+	ReorderTimeout  time.Duration
+	ContentType     codec.ContentType
+	ContentEncoding codec.ContentEncoding
+}
+
+// Session is one end of a bidirectional stream over a pair of private
+// subjects. Each end receives on its own inbox and sends to the inbox of the
+// peer. Messages in both directions are sequenced and credit based flow
+// controlled, and each direction is terminated by an end marker.
+type Session struct {
+	nc     transport.Conn
+	params Params
+	inbox  string
+	buf    chan peanats.Msg
+	sub    transport.Unsubscriber
+	seq    *Sequencer
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	stop   func() bool
+
+	consumed uint
+	recvDone atomic.Bool
+
+	mu       sync.Mutex
+	peer     string
+	sent     uint64
+	sendDone bool
+	flow     bool
+	credits  uint64
+	credit   chan struct{}
+
+	once sync.Once
+	err  error
+}
+
+// Listen subscribes the inbox of a new session end. The session is torn down
+// when ctx is done.
+func Listen(ctx context.Context, nc transport.Conn, p Params) (*Session, error) {
+	p.Window = max(p.Window, 1)
+	s := &Session{
+		nc:     nc,
+		params: p,
+		inbox:  nats.NewInbox(),
+		// room for the whole window and the end marker
+		buf:    make(chan peanats.Msg, p.Window+1),
+		seq:    NewSequencer(p.ReorderWindow, p.ReorderTimeout),
+		credit: make(chan struct{}, 1),
+	}
+	s.ctx, s.cancel = context.WithCancelCause(ctx)
+	sub, err := nc.SubscribeHandler(s.ctx, s.inbox, peanats.MsgHandlerFunc(s.receive),
+		transport.SubscribeHandlerDispatcher(InlineDispatcher{}))
+	if err != nil {
+		s.cancel(err)
+		return nil, err
+	}
+	s.sub = sub
+	s.stop = context.AfterFunc(s.ctx, func() { _ = s.teardown() })
+	return s, nil
+}
+
+// Header returns the handshake header announcing the inbox and the window.
+func (s *Session) Header() peanats.Header {
+	h := peanats.Header{}
+	h.Set(HeaderSession, s.inbox)
+	h.Set(HeaderCredit, strconv.FormatUint(uint64(s.params.Window), 10))
+	return h
+}
+
+// Connect sets the peer from its handshake header. A peer that announces no
+// window receives without flow control.
+func (s *Session) Connect(h peanats.Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peer = h.Get(HeaderSession)
+	if n, err := strconv.ParseUint(h.Get(HeaderCredit), 10, 64); err == nil && n > 0 {
+		s.flow = true
+		s.credits = n
+	}
+}
+
+// Context is done when the session is torn down. context.Cause tells why.
+func (s *Session) Context() context.Context {
+	return s.ctx
+}
+
+// receive handles control messages right away and queues the rest for Recv.
+// It never blocks: a message that does not fit is dropped, which Recv detects
+// as a sequence gap.
+func (s *Session) receive(_ context.Context, msg peanats.Msg) error {
+	switch {
+	case IsStatus(msg, StatusCancel):
+		s.abort(ErrCanceled)
+	case len(msg.Data()) == 0 && msg.Header().Get(HeaderCredit) != "":
+		n, err := strconv.ParseUint(msg.Header().Get(HeaderCredit), 10, 64)
+		if err != nil {
+			return nil
+		}
+		s.mu.Lock()
+		s.credits += n
+		s.mu.Unlock()
+		select {
+		case s.credit <- struct{}{}:
+		default:
+		}
+	default:
+		select {
+		case s.buf <- msg:
+		default:
+		}
+	}
+	return nil
+}
+
+// acquire takes a credit, waiting for one if the peer asked for flow control
+// and none is left. Called with mu held; the lock is released while waiting.
+func (s *Session) acquire(ctx context.Context) error {
+	for {
+		if s.sendDone {
+			return ErrClosed
+		}
+		if !s.flow || s.credits > 0 {
+			if s.flow {
+				s.credits--
+			}
+			return nil
+		}
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			return ctx.Err()
+		case <-s.ctx.Done():
+			s.mu.Lock()
+			return context.Cause(s.ctx)
+		case <-s.credit:
+			s.mu.Lock()
+		}
+	}
+}
+
+// Send publishes v to the peer, waiting for credit if needed.
+func (s *Session) Send(ctx context.Context, v any) error {
+	if v == nil {
+		return ErrNilValue
+	}
+	if err := context.Cause(s.ctx); err != nil {
+		return err
+	}
+	h := peanats.Header{}
+	h.Set(codec.HeaderContentType, s.params.ContentType.String())
+	if s.params.ContentEncoding != 0 {
+		codec.SetContentEncoding(h, s.params.ContentEncoding)
+	}
+	data, err := codec.MarshalHeader(v, h)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.acquire(ctx); err != nil {
+		return err
+	}
+	s.sent++
+	h.Set(HeaderSeq, strconv.FormatUint(s.sent, 10))
+	return s.nc.Publish(ctx, Msg{Subj: s.peer, Head: h, Body: data})
+}
+
+// CloseSend sends the end marker. It needs no credit and is safe to call
+// more than once.
+func (s *Session) CloseSend(ctx context.Context) error {
+	if err := context.Cause(s.ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sendDone {
+		return nil
+	}
+	s.sendDone = true
+	s.sent++
+	h := peanats.Header{}
+	h.Set(HeaderStatus, StatusEnd)
+	h.Set(HeaderSeq, strconv.FormatUint(s.sent, 10))
+	return s.nc.Publish(ctx, Msg{Subj: s.peer, Head: h})
+}
+
+// Recv returns the next message from the peer, or io.EOF once the peer
+// closed its side. Recv must not be called concurrently.
+func (s *Session) Recv(ctx context.Context) (peanats.Msg, error) {
+	if s.recvDone.Load() {
+		return nil, io.EOF
+	}
+	if msg, ok := s.seq.Pop(); ok {
+		return s.deliver(ctx, msg)
+	}
+	var (
+		stall <-chan time.Time
+		idle  *time.Timer
+	)
+	if s.params.IdleTimeout > 0 {
+		idle = time.NewTimer(s.params.IdleTimeout)
+		defer idle.Stop()
+		stall = idle.C
+	}
+	for {
+		gap := s.seq.Expired()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.ctx.Done():
+			return nil, context.Cause(s.ctx)
+		case <-stall:
+			s.abort(ErrStalled)
+			return nil, ErrStalled
+		case <-gap:
+			err := s.seq.Gap()
+			s.abort(err)
+			return nil, err
+		case msg := <-s.buf:
+			if idle != nil {
+				idle.Reset(s.params.IdleTimeout)
+			}
+			if IsStatus(msg, StatusHeartbeat) {
+				continue
+			}
+			msg, err := s.seq.Push(msg)
+			if err != nil {
+				s.abort(err)
+				return nil, err
+			}
+			if msg != nil {
+				return s.deliver(ctx, msg)
+			}
+		}
+	}
+}
+
+// deliver returns a message in sequence, granting the peer more credit once
+// half of the window was consumed.
+func (s *Session) deliver(ctx context.Context, msg peanats.Msg) (peanats.Msg, error) {
+	if IsStatus(msg, StatusEnd) {
+		s.recvDone.Store(true)
+		return nil, io.EOF
+	}
+	s.consumed++
+	if s.consumed >= max(s.params.Window/2, 1) {
+		h := peanats.Header{}
+		h.Set(HeaderCredit, strconv.FormatUint(uint64(s.consumed), 10))
+		s.consumed = 0
+		s.mu.Lock()
+		peer := s.peer
+		s.mu.Unlock()
+		if err := s.nc.Publish(ctx, Msg{Subj: peer, Head: h}); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// Close tears the session down. Unless both sides have ended, the peer is
+// told to stop.
+func (s *Session) Close() error {
+	s.stop()
+	s.cancel(ErrClosed)
+	return s.teardown()
+}
+
+func (s *Session) abort(cause error) {
+	s.stop()
+	s.cancel(cause)
+	_ = s.teardown()
+}
+
+func (s *Session) teardown() error {
+	s.once.Do(func() {
+		s.mu.Lock()
+		peer, done := s.peer, s.sendDone && s.recvDone.Load()
+		s.sendDone = true
+		s.mu.Unlock()
+		var err error
+		if peer != "" && !done && !errors.Is(context.Cause(s.ctx), ErrCanceled) {
+			h := peanats.Header{}
+			h.Set(HeaderStatus, StatusCancel)
+			err = s.nc.Publish(context.Background(), Msg{Subj: peer, Head: h})
+		}
+		s.err = errors.Join(err, s.sub.Unsubscribe())
+	})
+	return s.err
+}
@@ -0,0 +1,148 @@
+// Package xstream holds the wire protocol shared by the streaming parts of
+// the requester and responder packages.
+package xstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mikluko/peanats"
+)
+
+const (
+	// HeaderSession names the subject a session peer receives messages on.
+	HeaderSession = "Peanats-Session"
+	// HeaderCredit carries the number of messages the peer may send before it
+	// has to wait for more credit.
+	HeaderCredit = "Peanats-Credit"
+	// HeaderSeq carries the 1-based position of a message in the stream,
+	// including the terminating message.
+	HeaderSeq = "Peanats-Seq"
+	// HeaderStatus marks header-only protocol messages.
+	HeaderStatus = "Peanats-Status"
+
+	StatusHeartbeat = "heartbeat"
+	StatusCancel    = "cancel"
+	StatusEnd       = "end"
+)
+
+var (
+	ErrStalled     = errors.New("stream stalled")
+	ErrSequenceGap = errors.New("sequence gap in stream")
+	ErrCanceled    = errors.New("canceled by peer")
+	ErrClosed      = errors.New("session is closed")
+	ErrNilValue    = errors.New("nil value can not be sent")
+)
+
+// IsStatus reports whether msg is a header-only protocol message with the
+// given status.
+func IsStatus(msg peanats.Msg, status string) bool {
+	return len(msg.Data()) == 0 && msg.Header().Get(HeaderStatus) == status
+}
+
+// Sequencer restores the order of sequenced messages and drops duplicates.
+type Sequencer struct {
+	next    uint64
+	window  uint64
+	timeout time.Duration
+	pending map[uint64]peanats.Msg
+	// since is when the missing message started being waited for
+	since time.Time
+}
+
+// NewSequencer creates a sequencer holding back at most window messages that
+// arrived ahead of a missing one, for at most timeout. Zero timeout waits for
+// the missing message indefinitely.
+func NewSequencer(window uint, timeout time.Duration) *Sequencer {
+	return &Sequencer{next: 1, window: uint64(window), timeout: timeout, pending: make(map[uint64]peanats.Msg)}
+}
+
+// Push accepts a received message and returns it when it is the next one in
+// sequence. Messages arriving early are held back and nil is returned.
+// Messages without the sequence header are passed through unchecked.
+func (s *Sequencer) Push(msg peanats.Msg) (peanats.Msg, error) {
+	v := msg.Header().Get(HeaderSeq)
+	if v == "" {
+		return msg, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid sequence number %q", ErrSequenceGap, v)
+	}
+	switch {
+	case n < s.next:
+		return nil, nil // duplicate
+	case n == s.next:
+		s.next++
+		s.since = time.Now()
+		return msg, nil
+	case n-s.next > s.window:
+		return nil, fmt.Errorf("%w: expected %d, got %d", ErrSequenceGap, s.next, n)
+	default:
+		if len(s.pending) == 0 {
+			s.since = time.Now()
+		}
+		s.pending[n] = msg
+		return nil, nil
+	}
+}
+
+// Pop returns the next message in sequence if it was held back.
+func (s *Sequencer) Pop() (peanats.Msg, bool) {
+	msg, ok := s.pending[s.next]
+	if ok {
+		delete(s.pending, s.next)
+		s.next++
+		s.since = time.Now()
+	}
+	return msg, ok
+}
+
+// Expired returns a channel receiving when held back messages waited for a
+// missing one longer than the timeout, or nil if there is nothing to wait
+// for. It has to be called anew after every Push.
+func (s *Sequencer) Expired() <-chan time.Time {
+	if s.timeout == 0 || len(s.pending) == 0 {
+		return nil
+	}
+	return time.After(time.Until(s.since.Add(s.timeout)))
+}
+
+// Gap returns the error reporting the missing message once Expired fired.
+func (s *Sequencer) Gap() error {
+	return fmt.Errorf("%w: %d not received within %s", ErrSequenceGap, s.next, s.timeout)
+}
+
+// InlineDispatcher runs tasks on the subscription goroutine, which keeps
+// messages in order. It is only used with tasks that never block or fail.
+type InlineDispatcher struct{}
+
+func (InlineDispatcher) Dispatch(f func() error) {
+	_ = f()
+}
+
+func (InlineDispatcher) Wait(context.Context) error {
+	return nil
+}
+
+// Msg is a message built for publishing.
+type Msg struct {
+	Subj string
+	Head peanats.Header
+	Body []byte
+}
+
+func (m Msg) Subject() string {
+	return m.Subj
+}
+
+func (m Msg) Header() peanats.Header {
+	return m.Head
+}
+
+func (m Msg) Data() []byte {
+	return m.Body
+}
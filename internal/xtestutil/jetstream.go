@@ -0,0 +1,27 @@
+package xtestutil
+
+import (
+	"testing"
+
+	natsrv "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// JetStream connects to srv and returns a JetStream context on the
+// connection. The underlying connection is available through Conn.
+func JetStream(tb testing.TB, srv *natsrv.Server, opts ...nats.Option) jetstream.JetStream {
+	nc := Must(nats.Connect(srv.ClientURL(), opts...))
+	tb.Cleanup(nc.Close)
+	return Must(jetstream.New(nc))
+}
+
+// Stream creates a stream with cfg.
+func Stream(tb testing.TB, js jetstream.JetStream, cfg jetstream.StreamConfig) jetstream.Stream {
+	return Must(js.CreateStream(tb.Context(), cfg))
+}
+
+// KeyValue creates a key-value bucket with cfg.
+func KeyValue(tb testing.TB, js jetstream.JetStream, cfg jetstream.KeyValueConfig) jetstream.KeyValue {
+	return Must(js.CreateKeyValue(tb.Context(), cfg))
+}
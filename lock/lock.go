@@ -0,0 +1,327 @@
+// Package lock implements distributed locks on top of JetStream key-value
+// buckets. A lock is a key holding the identity of its holder and the expiry
+// of the lease; it is taken with a compare-and-set write and kept by renewing
+// the lease until it is released.
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nuid"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/bucket"
+)
+
+var (
+	// ErrLocked is returned by TryAcquire when the lock is held by someone
+	// else.
+	ErrLocked = errors.New("lock is held")
+	// ErrLost is the cause of a lease context canceled because the lease
+	// could not be renewed in time or was taken over.
+	ErrLost = errors.New("lease lost")
+	// ErrReleased is the cause of a lease context canceled by Release.
+	ErrReleased = errors.New("lease released")
+)
+
+// Holder is the value stored under the key of a held lock.
+type Holder struct {
+	ID      string    `json:"id"`
+	Expires time.Time `json:"expires"`
+}
+
+// Lease is a held lock.
+type Lease interface {
+	// Name returns the name of the lock.
+	Name() string
+	// Token returns the fencing token of the lease. Tokens grow with every
+	// acquisition of the lock, so a resource guarded by the lock can reject
+	// writes carrying a token lower than one it has already seen.
+	Token() uint64
+	// Context is done when the lease is lost or released. context.Cause
+	// reports ErrLost or ErrReleased.
+	Context() context.Context
+	// Release stops renewing the lease and frees the lock. It returns
+	// ErrLost when the lease was lost before.
+	Release(context.Context) error
+}
+
+// Locker hands out leases on named locks.
+type Locker interface {
+	// Acquire takes the lock, waiting for it while it is held by someone
+	// else. The lease is renewed in the background for ttl at a time until it
+	// is released.
+	Acquire(ctx context.Context, name string, ttl time.Duration) (Lease, error)
+	// TryAcquire takes the lock if it is free and fails with ErrLocked
+	// otherwise.
+	TryAcquire(ctx context.Context, name string, ttl time.Duration) (Lease, error)
+}
+
+type LockerOption func(*lockerParams)
+
+type lockerParams struct {
+	holder string
+	prefix string
+}
+
+// LockerHolder sets the identity stored with the held locks. By default a
+// random one is generated for every Locker.
+func LockerHolder(id string) LockerOption {
+	return func(p *lockerParams) {
+		p.holder = id
+	}
+}
+
+// LockerKeyPrefix keeps the locks under the given key prefix.
+func LockerKeyPrefix(prefix string) LockerOption {
+	return func(p *lockerParams) {
+		p.prefix = prefix
+	}
+}
+
+// NewLocker creates a Locker keeping its locks in kv. Lock expiry is judged by
+// the local clock, so the clocks of the contenders should be kept in sync to
+// well within the lease ttl.
+func NewLocker(kv jetstream.KeyValue, opts ...LockerOption) Locker {
+	p := lockerParams{holder: nuid.Next()}
+	for _, o := range opts {
+		o(&p)
+	}
+	return &lockerImpl{
+		bucket: bucket.NewBucket[Holder](kv, bucket.BucketKeyPrefix(p.prefix)),
+		holder: p.holder,
+	}
+}
+
+type lockerImpl struct {
+	bucket bucket.Bucket[Holder]
+	holder string
+}
+
+func (l *lockerImpl) Acquire(ctx context.Context, name string, ttl time.Duration) (Lease, error) {
+	for {
+		lease, cur, err := l.try(ctx, name, ttl)
+		if !errors.Is(err, ErrLocked) {
+			return lease, err
+		}
+		if err := l.wait(ctx, name, cur); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (l *lockerImpl) TryAcquire(ctx context.Context, name string, ttl time.Duration) (Lease, error) {
+	lease, _, err := l.try(ctx, name, ttl)
+	return lease, err
+}
+
+// try takes the lock if it is free or its lease has expired. When the lock is
+// held it returns ErrLocked along with the current entry.
+func (l *lockerImpl) try(ctx context.Context, name string, ttl time.Duration) (Lease, bucket.Entry[Holder], error) {
+	for {
+		h := &Holder{ID: l.holder, Expires: time.Now().Add(ttl)}
+		rev, err := l.bucket.Create(ctx, &entry{key: name, value: h})
+		if err == nil {
+			return l.lease(name, ttl, rev, h.Expires), nil, nil
+		}
+		if !errors.Is(err, bucket.ErrKeyExists) {
+			return nil, nil, err
+		}
+		cur, err := l.bucket.Get(ctx, name)
+		if errors.Is(err, bucket.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if time.Now().Before(cur.Value().Expires) {
+			return nil, cur, fmt.Errorf("%w: %s", ErrLocked, name)
+		}
+		// the holder went away without releasing the lock
+		rev, err = l.bucket.Update(ctx, &entry{key: name, value: h, revision: cur.Revision()})
+		if bucket.IsConflict(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return l.lease(name, ttl, rev, h.Expires), nil, nil
+	}
+}
+
+// wait blocks until the lock held as cur changes or its lease expires.
+func (l *lockerImpl) wait(ctx context.Context, name string, cur bucket.Entry[Holder]) error {
+	// The watch gets a context of its own which is canceled only once the
+	// watcher is set up: canceling it while the watcher is being created
+	// races within nats.go.
+	wctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	w, err := l.bucket.Watch(wctx, name, bucket.WatcherResumeFromRevision(cur.Revision()+1))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = w.Stop() }()
+	defer context.AfterFunc(ctx, cancel)()
+	defer time.AfterFunc(time.Until(cur.Value().Expires), cancel).Stop()
+	for {
+		_, err := w.Next()
+		switch {
+		case errors.Is(err, bucket.ErrInitialValuesOver):
+			continue
+		case errors.Is(err, bucket.ErrDone):
+			return ctx.Err()
+		default:
+			return nil
+		}
+	}
+}
+
+func (l *lockerImpl) lease(name string, ttl time.Duration, rev uint64, expires time.Time) Lease {
+	s := &leaseImpl{
+		bucket:  l.bucket,
+		name:    name,
+		holder:  l.holder,
+		ttl:     ttl,
+		token:   rev,
+		rev:     rev,
+		expires: expires,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancelCause(context.Background())
+	s.expiry = time.AfterFunc(time.Until(expires), func() { s.cancel(ErrLost) })
+	go s.renew()
+	return s
+}
+
+type leaseImpl struct {
+	bucket bucket.Bucket[Holder]
+	name   string
+	holder string
+	ttl    time.Duration
+	token  uint64
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	expiry *time.Timer
+	stop   chan struct{}
+	done   chan struct{}
+
+	// owned by renew until done is closed
+	rev     uint64
+	expires time.Time
+	// expiry written by the last renewal, whether or not it went through
+	attempt time.Time
+
+	once sync.Once
+	err  error
+}
+
+func (s *leaseImpl) Name() string {
+	return s.name
+}
+
+func (s *leaseImpl) Token() uint64 {
+	return s.token
+}
+
+func (s *leaseImpl) Context() context.Context {
+	return s.ctx
+}
+
+// renew extends the lease every third of its ttl. Failed renewals are
+// retried until the lease expires, at which point the expiry timer cancels
+// the lease context even if a renewal is still under way.
+func (s *leaseImpl) renew() {
+	defer close(s.done)
+	t := time.NewTicker(max(s.ttl/3, time.Millisecond))
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.ctx.Done():
+			return
+		case <-t.C:
+		}
+		if err := s.extend(); bucket.IsConflict(err) {
+			s.cancel(ErrLost)
+			return
+		}
+	}
+}
+
+// extend writes a new expiry. The write is bounded by the current one, past
+// which the lease is lost anyway.
+func (s *leaseImpl) extend() error {
+	ctx, cancel := context.WithDeadline(context.Background(), s.expires)
+	defer cancel()
+	h := &Holder{ID: s.holder, Expires: time.Now().Add(s.ttl)}
+	s.attempt = h.Expires
+	rev, err := s.bucket.Update(ctx, &entry{key: s.name, value: h, revision: s.rev})
+	if err != nil {
+		return err
+	}
+	if !s.expiry.Stop() {
+		// expired while the write was in flight
+		return nil
+	}
+	s.rev, s.expires = rev, h.Expires
+	s.expiry.Reset(time.Until(h.Expires))
+	return nil
+}
+
+func (s *leaseImpl) Release(ctx context.Context) error {
+	s.once.Do(func() {
+		close(s.stop)
+		<-s.done
+		s.expiry.Stop()
+		s.cancel(ErrReleased)
+		if errors.Is(context.Cause(s.ctx), ErrLost) {
+			s.err = ErrLost
+			return
+		}
+		err := s.bucket.Delete(ctx, s.name, jetstream.LastRevision(s.rev))
+		if bucket.IsConflict(err) {
+			err = s.retryDelete(ctx)
+		}
+		s.err = err
+	})
+	return s.err
+}
+
+// retryDelete deletes the lock once more when the last renewal went through
+// although it reported an error, leaving the lock at a revision unknown to
+// the lease.
+func (s *leaseImpl) retryDelete(ctx context.Context) error {
+	cur, err := s.bucket.Get(ctx, s.name)
+	if errors.Is(err, bucket.ErrKeyNotFound) {
+		return ErrLost
+	}
+	if err != nil {
+		return err
+	}
+	if cur.Value().ID != s.holder || !cur.Value().Expires.Equal(s.attempt) {
+		return ErrLost
+	}
+	err = s.bucket.Delete(ctx, s.name, jetstream.LastRevision(cur.Revision()))
+	if bucket.IsConflict(err) {
+		return ErrLost
+	}
+	return err
+}
+
+type entry struct {
+	key      string
+	value    *Holder
+	revision uint64
+}
+
+func (e *entry) Key() string            { return e.key }
+func (e *entry) Header() peanats.Header { return peanats.Header{} }
+func (e *entry) Value() *Holder         { return e.value }
+func (e *entry) Revision() uint64       { return e.revision }
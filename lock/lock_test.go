@@ -0,0 +1,168 @@
+package lock_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/bucket"
+	"github.com/mikluko/peanats/internal/xtestutil"
+	"github.com/mikluko/peanats/lock"
+)
+
+func setupKeyValue(t *testing.T) jetstream.KeyValue {
+	js := xtestutil.JetStream(t, xtestutil.Server(t))
+	return xtestutil.KeyValue(t, js, jetstream.KeyValueConfig{Bucket: "locks"})
+}
+
+type holderEntry struct {
+	key string
+	val *lock.Holder
+}
+
+func (e *holderEntry) Key() string            { return e.key }
+func (e *holderEntry) Header() peanats.Header { return peanats.Header{} }
+func (e *holderEntry) Value() *lock.Holder    { return e.val }
+
+// faultyKeyValue makes updates misbehave on demand: stalled updates hang
+// until their context is done, lost replies go through but report a timeout.
+type faultyKeyValue struct {
+	jetstream.KeyValue
+	stall, loseReply atomic.Bool
+}
+
+func (kv *faultyKeyValue) Update(ctx context.Context, key string, value []byte, rev uint64) (uint64, error) {
+	if kv.stall.Load() {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	rev, err := kv.KeyValue.Update(ctx, key, value, rev)
+	if err == nil && kv.loseReply.Load() {
+		return 0, context.DeadlineExceeded
+	}
+	return rev, err
+}
+
+func TestLocker(t *testing.T) {
+	kv := setupKeyValue(t)
+	alice := lock.NewLocker(kv, lock.LockerHolder("alice"), lock.LockerKeyPrefix("app"))
+	bob := lock.NewLocker(kv, lock.LockerHolder("bob"), lock.LockerKeyPrefix("app"))
+
+	t.Run("exclusive", func(t *testing.T) {
+		lease, err := alice.Acquire(t.Context(), "exclusive", time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, "exclusive", lease.Name())
+
+		_, err = bob.TryAcquire(t.Context(), "exclusive", time.Second)
+		assert.True(t, errors.Is(err, lock.ErrLocked))
+
+		require.NoError(t, lease.Release(t.Context()))
+		require.NoError(t, lease.Release(t.Context()))
+		assert.True(t, errors.Is(context.Cause(lease.Context()), lock.ErrReleased))
+
+		next, err := bob.TryAcquire(t.Context(), "exclusive", time.Second)
+		require.NoError(t, err)
+		assert.Greater(t, next.Token(), lease.Token())
+		require.NoError(t, next.Release(t.Context()))
+	})
+	t.Run("wait for release", func(t *testing.T) {
+		lease, err := alice.Acquire(t.Context(), "wait", time.Minute)
+		require.NoError(t, err)
+		time.AfterFunc(200*time.Millisecond, func() { _ = lease.Release(context.Background()) })
+
+		start := time.Now()
+		next, err := bob.Acquire(t.Context(), "wait", time.Minute)
+		require.NoError(t, err)
+		assert.Less(t, time.Since(start), 10*time.Second)
+		require.NoError(t, next.Release(t.Context()))
+	})
+	t.Run("renewal", func(t *testing.T) {
+		lease, err := alice.Acquire(t.Context(), "renewal", 300*time.Millisecond)
+		require.NoError(t, err)
+		time.Sleep(time.Second)
+
+		_, err = bob.TryAcquire(t.Context(), "renewal", time.Second)
+		assert.True(t, errors.Is(err, lock.ErrLocked))
+		assert.NoError(t, lease.Context().Err())
+		require.NoError(t, lease.Release(t.Context()))
+	})
+	t.Run("expired holder", func(t *testing.T) {
+		b := bucket.NewBucket[lock.Holder](kv, bucket.BucketKeyPrefix("app"))
+		_, err := b.Put(t.Context(), &holderEntry{key: "expired", val: &lock.Holder{ID: "crashed", Expires: time.Now().Add(200 * time.Millisecond)}})
+		require.NoError(t, err)
+
+		lease, err := bob.Acquire(t.Context(), "expired", time.Second)
+		require.NoError(t, err)
+		ent, err := b.Get(t.Context(), "expired")
+		require.NoError(t, err)
+		assert.Equal(t, "bob", ent.Value().ID)
+		require.NoError(t, lease.Release(t.Context()))
+	})
+	t.Run("lost", func(t *testing.T) {
+		lease, err := alice.Acquire(t.Context(), "lost", 300*time.Millisecond)
+		require.NoError(t, err)
+		_, err = kv.Put(t.Context(), "app.lost", []byte("overwritten"))
+		require.NoError(t, err)
+
+		select {
+		case <-lease.Context().Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("lease not lost")
+		}
+		assert.True(t, errors.Is(context.Cause(lease.Context()), lock.ErrLost))
+		assert.True(t, errors.Is(lease.Release(t.Context()), lock.ErrLost))
+	})
+	t.Run("canceled", func(t *testing.T) {
+		lease, err := alice.Acquire(t.Context(), "canceled", time.Minute)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = lease.Release(context.Background()) })
+
+		ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+		defer cancel()
+		_, err = bob.Acquire(ctx, "canceled", time.Minute)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+}
+
+func TestLease_Faults(t *testing.T) {
+	kv := setupKeyValue(t)
+	faulty := &faultyKeyValue{KeyValue: kv}
+	alice := lock.NewLocker(faulty, lock.LockerHolder("alice"))
+	bob := lock.NewLocker(kv, lock.LockerHolder("bob"))
+
+	t.Run("stalled renewal", func(t *testing.T) {
+		defer faulty.stall.Store(false)
+		lease, err := alice.Acquire(t.Context(), "stalled", 300*time.Millisecond)
+		require.NoError(t, err)
+		faulty.stall.Store(true)
+
+		next, err := bob.Acquire(t.Context(), "stalled", time.Minute)
+		require.NoError(t, err)
+		select {
+		case <-lease.Context().Done():
+		default:
+			t.Fatal("lease still held after being taken over")
+		}
+		assert.True(t, errors.Is(context.Cause(lease.Context()), lock.ErrLost))
+		assert.True(t, errors.Is(lease.Release(t.Context()), lock.ErrLost))
+		require.NoError(t, next.Release(t.Context()))
+	})
+	t.Run("release after lost reply", func(t *testing.T) {
+		defer faulty.loseReply.Store(false)
+		lease, err := alice.Acquire(t.Context(), "reply", 300*time.Millisecond)
+		require.NoError(t, err)
+		faulty.loseReply.Store(true)
+		time.Sleep(150 * time.Millisecond)
+
+		require.NoError(t, lease.Release(t.Context()))
+		_, err = kv.Get(t.Context(), "reply")
+		assert.True(t, errors.Is(err, jetstream.ErrKeyNotFound))
+	})
+}
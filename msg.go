@@ -13,6 +13,16 @@ import (
 
 type Header = textproto.MIMEHeader
 
+// CloneHeader returns a deep copy of h. The copy is never nil, so it can be
+// written to right away.
+func CloneHeader(h Header) Header {
+	res := make(Header, len(h))
+	for k, v := range h {
+		res[k] = append([]string(nil), v...)
+	}
+	return res
+}
+
 type Msg interface {
 	Subject() string
 	Header() Header
@@ -151,3 +151,15 @@ func TestChainMsgMiddleware(t *testing.T) {
 		}
 	})
 }
+
+func TestCloneHeader(t *testing.T) {
+	h := peanats.Header{"Foo": {"bar"}}
+	c := peanats.CloneHeader(h)
+	c.Add("Foo", "baz")
+	if got := h.Values("Foo"); len(got) != 1 {
+		t.Errorf("Original header changed: %v", got)
+	}
+	if c := peanats.CloneHeader(nil); c == nil {
+		t.Error("Clone of nil header is nil")
+	}
+}
@@ -0,0 +1,81 @@
+// Package outbox implements the transactional outbox pattern: messages are
+// stored in the same transaction as the business data and published to
+// JetStream by a relay afterwards.
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nuid"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/publisher"
+)
+
+// Record is an encoded message waiting to be published.
+type Record struct {
+	// ID is used as Nats-Msg-Id, so that a record published more than once
+	// is stored in the stream only once.
+	ID        string         `json:"id"`
+	Subject   string         `json:"subject"`
+	Header    peanats.Header `json:"header,omitempty"`
+	Data      []byte         `json:"data,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	Attempts  int            `json:"attempts,omitempty"`
+	LastError string         `json:"last_error,omitempty"`
+}
+
+// NewRecord encodes v exactly as publisher.Publish would and wraps it into a
+// record with a unique ID.
+func NewRecord(subj string, v any, opts ...publisher.PublishOption) (Record, error) {
+	header, data, err := publisher.Marshal(v, opts...)
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{
+		ID:        nuid.Next(),
+		Subject:   subj,
+		Header:    header,
+		Data:      data,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+func (r Record) msg() peanats.Msg {
+	return msg{r}
+}
+
+type msg struct {
+	r Record
+}
+
+func (m msg) Subject() string {
+	return m.r.Subject
+}
+
+func (m msg) Header() peanats.Header {
+	return m.r.Header
+}
+
+func (m msg) Data() []byte {
+	return m.r.Data
+}
+
+// Appender stores records. Implementations are expected to write through the
+// caller's database transaction, so that records are committed or rolled back
+// together with the business data.
+type Appender interface {
+	Append(context.Context, ...Record) error
+}
+
+// Store is the relay side of the outbox storage.
+type Store interface {
+	// Pending returns up to n undelivered records, oldest first.
+	Pending(ctx context.Context, n int) ([]Record, error)
+	// MarkDelivered marks the records as published.
+	MarkDelivered(ctx context.Context, ids ...string) error
+	// MarkFailed increments the attempt counter of the record and keeps the
+	// error for inspection.
+	MarkFailed(ctx context.Context, id string, err error) error
+}
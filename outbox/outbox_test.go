@@ -0,0 +1,242 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/codec"
+	"github.com/mikluko/peanats/internal/xtestutil"
+	"github.com/mikluko/peanats/outbox"
+	"github.com/mikluko/peanats/publisher"
+)
+
+type event struct {
+	Seq int `json:"seq" msgpack:"seq"`
+}
+
+func setupOutboxTest(t *testing.T) jetstream.JetStream {
+	js := xtestutil.JetStream(t, xtestutil.Server(t))
+	xtestutil.Stream(t, js, jetstream.StreamConfig{
+		Name:     "events",
+		Subjects: []string{"events.>"},
+	})
+	return js
+}
+
+func TestNewRecord(t *testing.T) {
+	r, err := outbox.NewRecord("events.1", &event{Seq: 1},
+		publisher.WithContentType(codec.Msgpack), publisher.WithContentEncoding(codec.S2))
+	require.NoError(t, err)
+	assert.NotEmpty(t, r.ID)
+
+	header, data, err := publisher.Marshal(&event{Seq: 1},
+		publisher.WithContentType(codec.Msgpack), publisher.WithContentEncoding(codec.S2))
+	require.NoError(t, err)
+	assert.Equal(t, header, r.Header)
+	assert.Equal(t, data, r.Data)
+}
+
+func TestRelay(t *testing.T) {
+	stores := map[string]func(t *testing.T) interface {
+		outbox.Appender
+		outbox.Store
+	}{
+		"memory": func(t *testing.T) interface {
+			outbox.Appender
+			outbox.Store
+		} {
+			return outbox.NewMemoryStore()
+		},
+		"file": func(t *testing.T) interface {
+			outbox.Appender
+			outbox.Store
+		} {
+			s, err := outbox.NewFileStore(filepath.Join(t.TempDir(), "outbox.json"))
+			require.NoError(t, err)
+			return s
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			js := setupOutboxTest(t)
+			store := newStore(t)
+			for i := 0; i < 25; i++ {
+				r, err := outbox.NewRecord(fmt.Sprintf("events.%d", i), &event{Seq: i})
+				require.NoError(t, err)
+				require.NoError(t, store.Append(t.Context(), r))
+			}
+
+			relay := outbox.NewRelay(store, publisher.NewJetstream(js),
+				outbox.RelayBatchSize(10), outbox.RelayInterval(10*time.Millisecond))
+			ctx, cancel := context.WithCancel(t.Context())
+			done := make(chan error)
+			go func() { done <- relay.Run(ctx) }()
+
+			assert.Eventually(t, func() bool {
+				pending, err := store.Pending(t.Context(), 100)
+				return err == nil && len(pending) == 0
+			}, 5*time.Second, 10*time.Millisecond)
+			cancel()
+			require.NoError(t, <-done)
+
+			s, err := js.Stream(t.Context(), "events")
+			require.NoError(t, err)
+			info, err := s.Info(t.Context())
+			require.NoError(t, err)
+			assert.Equal(t, uint64(25), info.State.Msgs)
+
+			m, err := s.GetMsg(t.Context(), 3)
+			require.NoError(t, err)
+			var v event
+			require.NoError(t, codec.UnmarshalHeader(m.Data, &v, peanats.Header(m.Header)))
+			assert.Equal(t, event{Seq: 2}, v)
+		})
+	}
+}
+
+func TestRelay_Deliver(t *testing.T) {
+	t.Run("duplicate delivery", func(t *testing.T) {
+		js := setupOutboxTest(t)
+		r, err := outbox.NewRecord("events.1", &event{Seq: 1})
+		require.NoError(t, err)
+
+		// the same record delivered by two relays, e.g. after a crash between
+		// publishing and marking it delivered
+		for i := 0; i < 2; i++ {
+			store := outbox.NewMemoryStore()
+			require.NoError(t, store.Append(t.Context(), r))
+			n, err := outbox.NewRelay(store, publisher.NewJetstream(js)).Deliver(t.Context())
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+		}
+
+		s, err := js.Stream(t.Context(), "events")
+		require.NoError(t, err)
+		info, err := s.Info(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), info.State.Msgs)
+	})
+	t.Run("failure keeps order", func(t *testing.T) {
+		pub := &flakyPublisher{failOn: "events.1"}
+		store := outbox.NewMemoryStore()
+		for i := 0; i < 3; i++ {
+			r, err := outbox.NewRecord(fmt.Sprintf("events.%d", i), &event{Seq: i})
+			require.NoError(t, err)
+			require.NoError(t, store.Append(t.Context(), r))
+		}
+		relay := outbox.NewRelay(store, pub)
+
+		n, err := relay.Deliver(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		pending, err := store.Pending(t.Context(), 10)
+		require.NoError(t, err)
+		require.Len(t, pending, 2)
+		assert.Equal(t, "events.1", pending[0].Subject)
+		assert.Equal(t, 1, pending[0].Attempts)
+		assert.Equal(t, "boom", pending[0].LastError)
+
+		pub.failOn = ""
+		n, err = relay.Deliver(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, []string{"events.0", "events.1", "events.2"}, pub.published)
+	})
+	t.Run("dead letter", func(t *testing.T) {
+		pub := &flakyPublisher{failOn: "events.1"}
+		store := outbox.NewMemoryStore()
+		for i := 0; i < 3; i++ {
+			r, err := outbox.NewRecord(fmt.Sprintf("events.%d", i), &event{Seq: i})
+			require.NoError(t, err)
+			require.NoError(t, store.Append(t.Context(), r))
+		}
+		var dead []outbox.Record
+		relay := outbox.NewRelay(store, pub,
+			outbox.RelayMaxAttempts(2),
+			outbox.RelayDeadLetter(func(_ context.Context, r outbox.Record, err error) error {
+				assert.EqualError(t, err, "boom")
+				dead = append(dead, r)
+				return nil
+			}))
+
+		n, err := relay.Deliver(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Empty(t, dead)
+
+		n, err = relay.Deliver(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		require.Len(t, dead, 1)
+		assert.Equal(t, "events.1", dead[0].Subject)
+		assert.Equal(t, 2, dead[0].Attempts)
+		assert.Equal(t, "boom", dead[0].LastError)
+		assert.Equal(t, []string{"events.0", "events.2"}, pub.published)
+
+		pending, err := store.Pending(t.Context(), 10)
+		require.NoError(t, err)
+		assert.Empty(t, pending)
+	})
+	t.Run("batch size clamped", func(t *testing.T) {
+		store := outbox.NewMemoryStore()
+		for i := 0; i < 2; i++ {
+			r, err := outbox.NewRecord(fmt.Sprintf("events.%d", i), &event{Seq: i})
+			require.NoError(t, err)
+			require.NoError(t, store.Append(t.Context(), r))
+		}
+		n, err := outbox.NewRelay(store, &flakyPublisher{}, outbox.RelayBatchSize(0)).Deliver(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+}
+
+func TestFileStore_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	s, err := outbox.NewFileStore(path)
+	require.NoError(t, err)
+	r1, err := outbox.NewRecord("events.1", &event{Seq: 1})
+	require.NoError(t, err)
+	r2, err := outbox.NewRecord("events.2", &event{Seq: 2})
+	require.NoError(t, err)
+	require.NoError(t, s.Append(t.Context(), r1, r2))
+	require.NoError(t, s.MarkDelivered(t.Context(), r1.ID))
+
+	s, err = outbox.NewFileStore(path)
+	require.NoError(t, err)
+	pending, err := s.Pending(t.Context(), 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, r2.ID, pending[0].ID)
+	assert.Equal(t, r2.Data, pending[0].Data)
+}
+
+type flakyPublisher struct {
+	mu        sync.Mutex
+	failOn    string
+	published []string
+}
+
+func (p *flakyPublisher) Publish(context.Context, string, any, ...publisher.PublishOption) (*publisher.PubAck, error) {
+	panic("not implemented")
+}
+
+func (p *flakyPublisher) PublishMsg(_ context.Context, m peanats.Msg, _ ...publisher.PublishOption) (*publisher.PubAck, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if m.Subject() == p.failOn {
+		return nil, errors.New("boom")
+	}
+	p.published = append(p.published, m.Subject())
+	return &publisher.PubAck{Stream: "events", Sequence: uint64(len(p.published))}, nil
+}
@@ -0,0 +1,176 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/mikluko/peanats/publisher"
+)
+
+// DeadLetterHandler receives a record given up on after the maximum number
+// of attempts, along with the last publish error. Returning an error keeps
+// the record in the outbox, to be retried like any other failure.
+type DeadLetterHandler func(context.Context, Record, error) error
+
+type RelayOption func(*relayParams)
+
+type relayParams struct {
+	batch       int
+	interval    time.Duration
+	backoff     time.Duration
+	maxAttempts int
+	deadLetter  DeadLetterHandler
+}
+
+const (
+	DefaultRelayBatchSize = 100
+	DefaultRelayInterval  = time.Second
+)
+
+// RelayBatchSize sets how many records are read from the store at once.
+func RelayBatchSize(n int) RelayOption {
+	return func(p *relayParams) {
+		p.batch = max(n, 1)
+	}
+}
+
+// RelayInterval sets how long the relay sleeps when the outbox is drained.
+func RelayInterval(d time.Duration) RelayOption {
+	return func(p *relayParams) {
+		p.interval = d
+	}
+}
+
+// RelayRetryBackoff sets the pause after a failed publish. It doubles with
+// every consecutive failure and is capped at ten times the initial value.
+// Defaults to the relay interval.
+func RelayRetryBackoff(d time.Duration) RelayOption {
+	return func(p *relayParams) {
+		p.backoff = d
+	}
+}
+
+// RelayMaxAttempts makes the relay give up on a record once publishing it
+// failed n times, so that it stops holding back the records after it. The
+// record is handed to the dead letter handler and removed from the outbox.
+// By default records are retried forever.
+func RelayMaxAttempts(n int) RelayOption {
+	return func(p *relayParams) {
+		p.maxAttempts = max(n, 1)
+	}
+}
+
+// RelayDeadLetter sets the handler of records given up on with
+// RelayMaxAttempts. By default they are dropped.
+func RelayDeadLetter(h DeadLetterHandler) RelayOption {
+	return func(p *relayParams) {
+		p.deadLetter = h
+	}
+}
+
+// Relay moves records from the store to JetStream.
+type Relay interface {
+	// Run delivers records until ctx is done or the store fails.
+	Run(context.Context) error
+	// Deliver publishes a single batch and returns the number of delivered
+	// records. Records are published in order; delivery stops at the first
+	// failure so that a record is never overtaken by a later one, unless the
+	// record is given up on with RelayMaxAttempts.
+	Deliver(context.Context) (int, error)
+}
+
+func NewRelay(store Store, pub publisher.JetstreamPublisher, opts ...RelayOption) Relay {
+	p := relayParams{
+		batch:      DefaultRelayBatchSize,
+		interval:   DefaultRelayInterval,
+		deadLetter: func(context.Context, Record, error) error { return nil },
+	}
+	for _, o := range opts {
+		o(&p)
+	}
+	if p.backoff == 0 {
+		p.backoff = p.interval
+	}
+	return &relayImpl{store: store, pub: pub, params: p}
+}
+
+type relayImpl struct {
+	store  Store
+	pub    publisher.JetstreamPublisher
+	params relayParams
+	fails  int
+}
+
+func (r *relayImpl) Run(ctx context.Context) error {
+	for {
+		n, err := r.Deliver(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		var wait time.Duration
+		switch {
+		case r.fails > 0:
+			wait = min(r.params.backoff<<min(r.fails-1, 16), 10*r.params.backoff)
+		case n < r.params.batch:
+			wait = r.params.interval
+		default:
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (r *relayImpl) Deliver(ctx context.Context) (int, error) {
+	records, err := r.store.Pending(ctx, r.params.batch)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		done    = make([]string, 0, len(records))
+		n       int
+		failure error
+	)
+	for _, rec := range records {
+		_, failure = r.pub.PublishMsg(ctx, rec.msg(), publisher.WithMsgID(rec.ID))
+		if failure == nil {
+			done = append(done, rec.ID)
+			n++
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if err := r.store.MarkFailed(ctx, rec.ID, failure); err != nil {
+			return 0, err
+		}
+		rec.Attempts++
+		rec.LastError = failure.Error()
+		if r.params.maxAttempts == 0 || rec.Attempts < r.params.maxAttempts {
+			break
+		}
+		if err := r.params.deadLetter(ctx, rec, failure); err != nil {
+			break
+		}
+		// given up on, the record no longer holds back the next ones
+		done = append(done, rec.ID)
+		failure = nil
+	}
+	if len(done) > 0 {
+		if err := r.store.MarkDelivered(ctx, done...); err != nil {
+			return 0, err
+		}
+	}
+	if failure != nil {
+		r.fails++
+	} else {
+		r.fails = 0
+	}
+	return n, nil
+}
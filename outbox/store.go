@@ -0,0 +1,130 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// MemoryStore keeps records in memory. It implements both Appender and Store
+// and is meant for tests and examples.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) Append(_ context.Context, records ...Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func (s *MemoryStore) Pending(_ context.Context, n int) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.records[:min(n, len(s.records))]), nil
+}
+
+func (s *MemoryStore) MarkDelivered(_ context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = slices.DeleteFunc(s.records, func(r Record) bool {
+		return slices.Contains(ids, r.ID)
+	})
+	return nil
+}
+
+func (s *MemoryStore) MarkFailed(_ context.Context, id string, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.records {
+		if s.records[i].ID == id {
+			s.records[i].Attempts++
+			s.records[i].LastError = err.Error()
+		}
+	}
+	return nil
+}
+
+// FileStore keeps records in a JSON file that is rewritten on every change.
+// It survives restarts but is not suitable for high volumes.
+type FileStore struct {
+	mu   sync.Mutex // serializes writes to the file
+	mem  MemoryStore
+	path string
+}
+
+// NewFileStore opens the store at path, loading records left from a previous
+// run if the file exists.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.mem.records); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) Append(ctx context.Context, records ...Record) error {
+	return s.update(func() error { return s.mem.Append(ctx, records...) })
+}
+
+func (s *FileStore) Pending(ctx context.Context, n int) ([]Record, error) {
+	return s.mem.Pending(ctx, n)
+}
+
+func (s *FileStore) MarkDelivered(ctx context.Context, ids ...string) error {
+	return s.update(func() error { return s.mem.MarkDelivered(ctx, ids...) })
+}
+
+func (s *FileStore) MarkFailed(ctx context.Context, id string, err error) error {
+	return s.update(func() error { return s.mem.MarkFailed(ctx, id, err) })
+}
+
+// update applies fn and persists the result, writing to a temporary file
+// first so that a crash never leaves a truncated store behind.
+func (s *FileStore) update(fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := fn(); err != nil {
+		return err
+	}
+	s.mem.mu.Lock()
+	data, err := json.Marshal(s.mem.records)
+	s.mem.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
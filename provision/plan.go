@@ -0,0 +1,312 @@
+package provision
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Kind is the type of resource a Change applies to.
+type Kind uint8
+
+const (
+	_ Kind = iota
+	KindStream
+	KindConsumer
+	KindBucket
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindStream:
+		return "stream"
+	case KindConsumer:
+		return "consumer"
+	case KindBucket:
+		return "bucket"
+	default:
+		panic(fmt.Sprintf("unknown kind: %d", k))
+	}
+}
+
+// Action is what Ensure does to a resource.
+type Action uint8
+
+const (
+	// ActionNone means the resource already matches the spec.
+	ActionNone Action = iota
+	// ActionCreate means the resource does not exist and will be created.
+	ActionCreate
+	// ActionUpdate means the resource will be updated in place.
+	ActionUpdate
+	// ActionRecreate means an immutable field differs and the resource will be
+	// deleted and created again. This is always destructive.
+	ActionRecreate
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionNone:
+		return "none"
+	case ActionCreate:
+		return "create"
+	case ActionUpdate:
+		return "update"
+	case ActionRecreate:
+		return "recreate"
+	default:
+		panic(fmt.Sprintf("unknown action: %d", a))
+	}
+}
+
+// Diff is a single field that differs between the spec and the server.
+type Diff struct {
+	Field       string
+	Old         any
+	New         any
+	Destructive bool
+	immutable   bool
+}
+
+// Change is the planned action for a single resource.
+type Change struct {
+	Kind   Kind
+	Name   string
+	Action Action
+	Diffs  []Diff
+
+	stream       jetstream.StreamConfig
+	consumer     jetstream.ConsumerConfig
+	bucket       jetstream.KeyValueConfig
+	streamName   string
+	consumerName string
+}
+
+// Destructive reports whether the change may lose data.
+func (c *Change) Destructive() bool {
+	if c.Action == ActionRecreate {
+		return true
+	}
+	for _, d := range c.Diffs {
+		if d.Destructive {
+			return true
+		}
+	}
+	return false
+}
+
+// Plan is the ordered list of changes Ensure applies.
+type Plan struct {
+	Changes []Change
+}
+
+// Destructive reports whether any change in the plan may lose data.
+func (p *Plan) Destructive() bool {
+	for i := range p.Changes {
+		if p.Changes[i].Destructive() {
+			return true
+		}
+	}
+	return false
+}
+
+// Pending reports whether the plan contains any change at all.
+func (p *Plan) Pending() bool {
+	for _, c := range p.Changes {
+		if c.Action != ActionNone {
+			return true
+		}
+	}
+	return false
+}
+
+// String renders the plan in a human-readable form, one resource per line
+// followed by the differing fields.
+func (p *Plan) String() string {
+	b := new(strings.Builder)
+	for _, c := range p.Changes {
+		mark := "="
+		switch c.Action {
+		case ActionCreate:
+			mark = "+"
+		case ActionUpdate:
+			mark = "~"
+		case ActionRecreate:
+			mark = "!"
+		}
+		fmt.Fprintf(b, "%s %s %s (%s)\n", mark, c.Kind, c.Name, c.Action)
+		for _, d := range c.Diffs {
+			suffix := ""
+			if d.Destructive {
+				suffix = " (destructive)"
+			}
+			fmt.Fprintf(b, "    %s: %v -> %v%s\n", d.Field, d.Old, d.New, suffix)
+		}
+	}
+	return b.String()
+}
+
+func actionFor(diffs []Diff) Action {
+	if len(diffs) == 0 {
+		return ActionNone
+	}
+	for _, d := range diffs {
+		if d.immutable {
+			return ActionRecreate
+		}
+	}
+	return ActionUpdate
+}
+
+type rule uint8
+
+const (
+	// ruleMutable fields can be updated in place without losing data.
+	ruleMutable rule = iota
+	// ruleImmutable fields can only be changed by re-creating the resource.
+	ruleImmutable
+	// ruleLimit fields are retention limits where non-positive means
+	// unlimited; tightening them may discard data.
+	ruleLimit
+	// ruleSubset fields are string lists where dropping an element is
+	// destructive.
+	ruleSubset
+)
+
+var streamRules = map[string]rule{
+	"name":                 ruleImmutable,
+	"storage":              ruleImmutable,
+	"retention":            ruleImmutable,
+	"max_msgs":             ruleLimit,
+	"max_bytes":            ruleLimit,
+	"max_age":              ruleLimit,
+	"max_msgs_per_subject": ruleLimit,
+	"subjects":             ruleSubset,
+}
+
+var consumerRules = map[string]rule{
+	"name":           ruleImmutable,
+	"durable_name":   ruleImmutable,
+	"deliver_policy": ruleImmutable,
+	"opt_start_seq":  ruleImmutable,
+	"opt_start_time": ruleImmutable,
+	"ack_policy":     ruleImmutable,
+	"replay_policy":  ruleImmutable,
+	"max_waiting":    ruleImmutable,
+	"mem_storage":    ruleImmutable,
+}
+
+var bucketRules = map[string]rule{
+	"bucket":    ruleImmutable,
+	"storage":   ruleImmutable,
+	"history":   ruleLimit,
+	"ttl":       ruleLimit,
+	"max_bytes": ruleLimit,
+}
+
+// diff compares every field declared in want (i.e. non-zero) with the
+// corresponding field in have.
+func diff[T any](want, have T, rules map[string]rule) []Diff {
+	var diffs []Diff
+	wv, hv := reflect.ValueOf(want), reflect.ValueOf(have)
+	for i := 0; i < wv.NumField(); i++ {
+		wf, hf := wv.Field(i), hv.Field(i)
+		if wf.IsZero() || fieldEqual(wf, hf) {
+			continue
+		}
+		name := fieldName(wv.Type().Field(i))
+		d := Diff{Field: name, Old: hf.Interface(), New: wf.Interface()}
+		switch rules[name] {
+		case ruleImmutable:
+			d.Destructive, d.immutable = true, true
+		case ruleLimit:
+			d.Destructive = limitTightened(hf, wf)
+		case ruleSubset:
+			d.Destructive = subsetDropped(hf, wf)
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
+
+// merge overlays every field declared in want on top of have.
+func merge[T any](want, have T) T {
+	wv := reflect.ValueOf(want)
+	rv := reflect.ValueOf(&have).Elem()
+	for i := 0; i < wv.NumField(); i++ {
+		wf := wv.Field(i)
+		if wf.IsZero() {
+			continue
+		}
+		if wf.Kind() == reflect.Map && !rv.Field(i).IsNil() {
+			m := reflect.MakeMap(wf.Type())
+			for _, src := range []reflect.Value{rv.Field(i), wf} {
+				iter := src.MapRange()
+				for iter.Next() {
+					m.SetMapIndex(iter.Key(), iter.Value())
+				}
+			}
+			rv.Field(i).Set(m)
+			continue
+		}
+		rv.Field(i).Set(wf)
+	}
+	return have
+}
+
+// fieldEqual compares a declared field with the server value. Maps are
+// compared as subsets since the server may add its own entries.
+func fieldEqual(want, have reflect.Value) bool {
+	if want.Kind() == reflect.Map {
+		iter := want.MapRange()
+		for iter.Next() {
+			v := have.MapIndex(iter.Key())
+			if !v.IsValid() || !reflect.DeepEqual(v.Interface(), iter.Value().Interface()) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(want.Interface(), have.Interface())
+}
+
+func fieldName(f reflect.StructField) string {
+	tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if tag == "" || tag == "-" {
+		return f.Name
+	}
+	return tag
+}
+
+func limitTightened(have, want reflect.Value) bool {
+	h, w := numeric(have), numeric(want)
+	if w <= 0 {
+		return false
+	}
+	return h <= 0 || w < h
+}
+
+func numeric(v reflect.Value) int64 {
+	switch {
+	case v.CanInt():
+		return v.Int()
+	case v.CanUint():
+		return int64(v.Uint())
+	default:
+		panic(fmt.Sprintf("not a numeric field: %s", v.Type()))
+	}
+}
+
+func subsetDropped(have, want reflect.Value) bool {
+	hs, _ := have.Interface().([]string)
+	ws, _ := want.Interface().([]string)
+	for _, s := range hs {
+		if !slices.Contains(ws, s) {
+			return true
+		}
+	}
+	return false
+}
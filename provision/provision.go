@@ -0,0 +1,341 @@
+// Package provision declares JetStream streams, consumers and key-value buckets
+// and reconciles them against a server.
+/*
+
+A Spec lists the desired resources. Ensure reads the current state from the
+server, computes a Plan and applies it. Fields left at their zero value in the
+Spec are treated as "not declared": they are neither compared nor changed on
+existing resources, and new resources get the server defaults for them.
+
+Changes that may lose data or require the resource to be deleted and created
+again are destructive. Ensure refuses them unless EnsureAllowDestructive is
+given.
+
+	spec, err := provision.ReadYAML(f)
+	if err != nil {
+		return err
+	}
+	_, err = provision.Ensure(ctx, js, spec)
+*/
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Spec is the desired set of JetStream resources.
+type Spec struct {
+	Streams []Stream                   `json:"streams,omitempty"`
+	Buckets []jetstream.KeyValueConfig `json:"buckets,omitempty"`
+}
+
+// Stream is a stream configuration together with the consumers it owns.
+type Stream struct {
+	jetstream.StreamConfig
+	Consumers []jetstream.ConsumerConfig `json:"consumers,omitempty"`
+}
+
+// ErrDestructive is returned by Ensure when the plan contains destructive
+// changes and EnsureAllowDestructive is not set.
+var ErrDestructive = errors.New("plan contains destructive changes")
+
+// ErrUnnamedConsumer is returned by MakePlan for a consumer spec with neither
+// a durable name nor a name, which could not be looked up again.
+var ErrUnnamedConsumer = errors.New("consumer has no name")
+
+type jetStream interface {
+	Stream(ctx context.Context, stream string) (jetstream.Stream, error)
+	CreateStream(ctx context.Context, cfg jetstream.StreamConfig) (jetstream.Stream, error)
+	UpdateStream(ctx context.Context, cfg jetstream.StreamConfig) (jetstream.Stream, error)
+	DeleteStream(ctx context.Context, stream string) error
+	CreateKeyValue(ctx context.Context, cfg jetstream.KeyValueConfig) (jetstream.KeyValue, error)
+	UpdateKeyValue(ctx context.Context, cfg jetstream.KeyValueConfig) (jetstream.KeyValue, error)
+	DeleteKeyValue(ctx context.Context, bucket string) error
+}
+
+type EnsureOption func(*ensureParams)
+
+type ensureParams struct {
+	allowDestructive bool
+	dryRun           bool
+	out              io.Writer
+}
+
+// EnsureAllowDestructive permits changes that may lose data, including
+// deleting and re-creating resources whose immutable fields differ.
+func EnsureAllowDestructive() EnsureOption {
+	return func(p *ensureParams) {
+		p.allowDestructive = true
+	}
+}
+
+// EnsureDryRun makes Ensure print the plan to w instead of applying it. A nil
+// w prints to os.Stdout.
+func EnsureDryRun(w io.Writer) EnsureOption {
+	if w == nil {
+		w = os.Stdout
+	}
+	return func(p *ensureParams) {
+		p.dryRun = true
+		p.out = w
+	}
+}
+
+// Ensure brings the server in line with the spec and returns the plan it
+// executed. In dry-run mode the plan is only printed.
+func Ensure(ctx context.Context, js jetStream, spec Spec, opts ...EnsureOption) (*Plan, error) {
+	p := ensureParams{}
+	for _, o := range opts {
+		o(&p)
+	}
+	plan, err := MakePlan(ctx, js, spec)
+	if err != nil {
+		return nil, err
+	}
+	if p.dryRun {
+		_, err = io.WriteString(p.out, plan.String())
+		return plan, err
+	}
+	if plan.Destructive() && !p.allowDestructive {
+		return plan, ErrDestructive
+	}
+	return plan, plan.apply(ctx, js)
+}
+
+// MakePlan compares the spec with the server state without changing anything.
+func MakePlan(ctx context.Context, js jetStream, spec Spec) (*Plan, error) {
+	plan := &Plan{}
+	for _, cfg := range spec.Buckets {
+		c, err := planBucket(ctx, js, cfg)
+		if err != nil {
+			return nil, err
+		}
+		plan.Changes = append(plan.Changes, c)
+	}
+	for _, s := range spec.Streams {
+		c, str, err := planStream(ctx, js, s.StreamConfig)
+		if err != nil {
+			return nil, err
+		}
+		plan.Changes = append(plan.Changes, c)
+		for _, cfg := range s.Consumers {
+			if cfg.Durable == "" && cfg.Name == "" {
+				return nil, fmt.Errorf("stream %s: %w", s.Name, ErrUnnamedConsumer)
+			}
+			cc, err := planConsumer(ctx, str, c.Action, s.Name, cfg)
+			if err != nil {
+				return nil, err
+			}
+			plan.Changes = append(plan.Changes, cc)
+		}
+	}
+	return plan, nil
+}
+
+func planBucket(ctx context.Context, js jetStream, cfg jetstream.KeyValueConfig) (Change, error) {
+	cfg = normalizeBucket(cfg)
+	c := Change{Kind: KindBucket, Name: cfg.Bucket, bucket: cfg}
+	str, err := js.Stream(ctx, kvStreamName(cfg.Bucket))
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		c.Action = ActionCreate
+		return c, nil
+	} else if err != nil {
+		return c, fmt.Errorf("bucket %s: %w", cfg.Bucket, err)
+	}
+	existing := kvConfigFromStream(cfg.Bucket, str.CachedInfo().Config)
+	c.Diffs = diff(cfg, existing, bucketRules)
+	c.Action = actionFor(c.Diffs)
+	if c.Action == ActionUpdate {
+		c.bucket = merge(cfg, existing)
+	}
+	return c, nil
+}
+
+func planStream(ctx context.Context, js jetStream, cfg jetstream.StreamConfig) (Change, jetstream.Stream, error) {
+	c := Change{Kind: KindStream, Name: cfg.Name, stream: cfg}
+	str, err := js.Stream(ctx, cfg.Name)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		c.Action = ActionCreate
+		return c, nil, nil
+	} else if err != nil {
+		return c, nil, fmt.Errorf("stream %s: %w", cfg.Name, err)
+	}
+	existing := str.CachedInfo().Config
+	c.Diffs = diff(cfg, existing, streamRules)
+	c.Action = actionFor(c.Diffs)
+	if c.Action == ActionUpdate {
+		c.stream = merge(cfg, existing)
+	}
+	return c, str, nil
+}
+
+func planConsumer(ctx context.Context, str jetstream.Stream, streamAction Action, stream string, cfg jetstream.ConsumerConfig) (Change, error) {
+	name := cfg.Durable
+	if name == "" {
+		name = cfg.Name
+	}
+	c := Change{Kind: KindConsumer, Name: stream + "/" + name, streamName: stream, consumerName: name, consumer: cfg}
+	if str == nil || streamAction == ActionRecreate {
+		c.Action = ActionCreate
+		return c, nil
+	}
+	con, err := str.Consumer(ctx, name)
+	if errors.Is(err, jetstream.ErrConsumerNotFound) {
+		c.Action = ActionCreate
+		return c, nil
+	} else if err != nil {
+		return c, fmt.Errorf("consumer %s: %w", c.Name, err)
+	}
+	existing := con.CachedInfo().Config
+	c.Diffs = diff(cfg, existing, consumerRules)
+	c.Action = actionFor(c.Diffs)
+	if c.Action == ActionUpdate {
+		c.consumer = merge(cfg, existing)
+	}
+	return c, nil
+}
+
+func (p *Plan) apply(ctx context.Context, js jetStream) error {
+	for _, c := range p.Changes {
+		if err := c.apply(ctx, js); err != nil {
+			return fmt.Errorf("%s %s: %w", c.Kind, c.Name, err)
+		}
+	}
+	return nil
+}
+
+func (c *Change) apply(ctx context.Context, js jetStream) (err error) {
+	switch c.Kind {
+	case KindBucket:
+		switch c.Action {
+		case ActionRecreate:
+			if err = js.DeleteKeyValue(ctx, c.Name); err != nil {
+				return err
+			}
+			_, err = js.CreateKeyValue(ctx, c.bucket)
+		case ActionCreate:
+			_, err = js.CreateKeyValue(ctx, c.bucket)
+		case ActionUpdate:
+			_, err = js.UpdateKeyValue(ctx, c.bucket)
+		}
+	case KindStream:
+		switch c.Action {
+		case ActionRecreate:
+			if err = js.DeleteStream(ctx, c.Name); err != nil {
+				return err
+			}
+			_, err = js.CreateStream(ctx, c.stream)
+		case ActionCreate:
+			_, err = js.CreateStream(ctx, c.stream)
+		case ActionUpdate:
+			_, err = js.UpdateStream(ctx, c.stream)
+		}
+	case KindConsumer:
+		if c.Action == ActionNone {
+			return nil
+		}
+		var str jetstream.Stream
+		str, err = js.Stream(ctx, c.streamName)
+		if err != nil {
+			return err
+		}
+		switch c.Action {
+		case ActionRecreate:
+			if err = str.DeleteConsumer(ctx, c.consumerName); err != nil {
+				return err
+			}
+			_, err = str.CreateConsumer(ctx, c.consumer)
+		case ActionCreate:
+			_, err = str.CreateConsumer(ctx, c.consumer)
+		case ActionUpdate:
+			_, err = str.UpdateConsumer(ctx, c.consumer)
+		}
+	}
+	return err
+}
+
+const kvStreamPrefix = "KV_"
+
+func kvStreamName(bucket string) string {
+	return kvStreamPrefix + bucket
+}
+
+// kvConfigFromStream reverses the mapping jetstream applies when it creates the
+// stream backing a key-value bucket.
+func kvConfigFromStream(bucket string, s jetstream.StreamConfig) jetstream.KeyValueConfig {
+	cfg := jetstream.KeyValueConfig{
+		Bucket:      bucket,
+		Description: s.Description,
+		TTL:         s.MaxAge,
+		MaxBytes:    s.MaxBytes,
+		Storage:     s.Storage,
+		Replicas:    s.Replicas,
+		Placement:   s.Placement,
+		RePublish:   s.RePublish,
+		Compression: s.Compression == jetstream.S2Compression,
+	}
+	if s.MaxMsgSize > 0 {
+		cfg.MaxValueSize = s.MaxMsgSize
+	}
+	if s.MaxMsgsPerSubject > 0 && s.MaxMsgsPerSubject <= jetstream.KeyValueMaxHistory {
+		cfg.History = uint8(s.MaxMsgsPerSubject)
+	}
+	if s.AllowMsgTTL {
+		cfg.LimitMarkerTTL = s.SubjectDeleteMarkerTTL
+	}
+	if s.Mirror != nil {
+		m := *s.Mirror
+		m.Name = strings.TrimPrefix(m.Name, kvStreamPrefix)
+		cfg.Mirror = &m
+	}
+	for _, ss := range s.Sources {
+		src := *ss
+		src.Name = strings.TrimPrefix(src.Name, kvStreamPrefix)
+		if slices.Equal(src.SubjectTransforms, kvSourceTransforms(src.Name, bucket)) {
+			src.SubjectTransforms = nil
+		}
+		cfg.Sources = append(cfg.Sources, &src)
+	}
+	return cfg
+}
+
+// kvSourceTransforms returns the subject transform jetstream adds to a source
+// of a key-value bucket, mapping the keys of the source bucket into its own.
+func kvSourceTransforms(source, bucket string) []jetstream.SubjectTransformConfig {
+	return []jetstream.SubjectTransformConfig{{
+		Source:      kvSubjects(source),
+		Destination: kvSubjects(bucket),
+	}}
+}
+
+func kvSubjects(bucket string) string {
+	return "$KV." + bucket + ".>"
+}
+
+// normalizeBucket names the mirror and the sources of a bucket spec the way
+// kvConfigFromStream does, so that both compare equal.
+func normalizeBucket(cfg jetstream.KeyValueConfig) jetstream.KeyValueConfig {
+	if cfg.Mirror != nil {
+		m := *cfg.Mirror
+		m.Name = strings.TrimPrefix(m.Name, kvStreamPrefix)
+		cfg.Mirror = &m
+	}
+	if cfg.Sources != nil {
+		sources := make([]*jetstream.StreamSource, len(cfg.Sources))
+		for i, ss := range cfg.Sources {
+			src := *ss
+			src.Name = strings.TrimPrefix(src.Name, kvStreamPrefix)
+			sources[i] = &src
+		}
+		cfg.Sources = sources
+	}
+	return cfg
+}
@@ -0,0 +1,204 @@
+package provision_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats/internal/xtestutil"
+	"github.com/mikluko/peanats/provision"
+)
+
+func newJetStream(t *testing.T) jetstream.JetStream {
+	return xtestutil.JetStream(t, xtestutil.Server(t))
+}
+
+func testSpec() provision.Spec {
+	return provision.Spec{
+		Streams: []provision.Stream{{
+			StreamConfig: jetstream.StreamConfig{
+				Name:     "ORDERS",
+				Subjects: []string{"orders.>"},
+				MaxAge:   time.Hour,
+			},
+			Consumers: []jetstream.ConsumerConfig{{
+				Durable:   "billing",
+				AckPolicy: jetstream.AckExplicitPolicy,
+			}},
+		}},
+		Buckets: []jetstream.KeyValueConfig{{
+			Bucket:  "sessions",
+			History: 5,
+		}},
+	}
+}
+
+func TestEnsure(t *testing.T) {
+	t.Run("create and converge", func(t *testing.T) {
+		js := newJetStream(t)
+		plan, err := provision.Ensure(t.Context(), js, testSpec())
+		require.NoError(t, err)
+		require.Len(t, plan.Changes, 3)
+		for _, c := range plan.Changes {
+			assert.Equal(t, provision.ActionCreate, c.Action, c.Name)
+		}
+
+		s, err := js.Stream(t.Context(), "ORDERS")
+		require.NoError(t, err)
+		assert.Equal(t, time.Hour, s.CachedInfo().Config.MaxAge)
+		_, err = s.Consumer(t.Context(), "billing")
+		require.NoError(t, err)
+		kv, err := js.KeyValue(t.Context(), "sessions")
+		require.NoError(t, err)
+		st, err := kv.Status(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), st.History())
+
+		plan, err = provision.Ensure(t.Context(), js, testSpec())
+		require.NoError(t, err)
+		assert.False(t, plan.Pending(), plan.String())
+	})
+	t.Run("bucket settings converge", func(t *testing.T) {
+		js := newJetStream(t)
+		spec := provision.Spec{Buckets: []jetstream.KeyValueConfig{
+			{Bucket: "markers", LimitMarkerTTL: time.Minute},
+			{Bucket: "origin"},
+			{Bucket: "replica", Mirror: &jetstream.StreamSource{Name: "origin"}},
+			{Bucket: "merged", Sources: []*jetstream.StreamSource{{Name: "origin"}}},
+		}}
+		_, err := provision.Ensure(t.Context(), js, spec)
+		require.NoError(t, err)
+
+		plan, err := provision.Ensure(t.Context(), js, spec)
+		require.NoError(t, err)
+		assert.False(t, plan.Pending(), plan.String())
+	})
+	t.Run("unnamed consumer", func(t *testing.T) {
+		js := newJetStream(t)
+		spec := testSpec()
+		spec.Streams[0].Consumers = append(spec.Streams[0].Consumers, jetstream.ConsumerConfig{AckPolicy: jetstream.AckExplicitPolicy})
+		_, err := provision.Ensure(t.Context(), js, spec)
+		require.ErrorIs(t, err, provision.ErrUnnamedConsumer)
+	})
+	t.Run("safe update", func(t *testing.T) {
+		js := newJetStream(t)
+		_, err := provision.Ensure(t.Context(), js, testSpec())
+		require.NoError(t, err)
+
+		spec := testSpec()
+		spec.Streams[0].MaxAge = 2 * time.Hour
+		spec.Streams[0].Subjects = append(spec.Streams[0].Subjects, "refunds.>")
+		spec.Streams[0].Consumers[0].MaxDeliver = 3
+		spec.Buckets[0].History = 10
+
+		plan, err := provision.Ensure(t.Context(), js, spec)
+		require.NoError(t, err)
+		assert.False(t, plan.Destructive(), plan.String())
+		for _, c := range plan.Changes {
+			assert.Equal(t, provision.ActionUpdate, c.Action, c.Name)
+		}
+
+		s, err := js.Stream(t.Context(), "ORDERS")
+		require.NoError(t, err)
+		assert.Equal(t, 2*time.Hour, s.CachedInfo().Config.MaxAge)
+		assert.Equal(t, []string{"orders.>", "refunds.>"}, s.CachedInfo().Config.Subjects)
+		c, err := s.Consumer(t.Context(), "billing")
+		require.NoError(t, err)
+		assert.Equal(t, 3, c.CachedInfo().Config.MaxDeliver)
+	})
+	t.Run("destructive refused", func(t *testing.T) {
+		js := newJetStream(t)
+		_, err := provision.Ensure(t.Context(), js, testSpec())
+		require.NoError(t, err)
+
+		spec := testSpec()
+		spec.Streams[0].MaxMsgs = 100
+
+		plan, err := provision.Ensure(t.Context(), js, spec)
+		require.ErrorIs(t, err, provision.ErrDestructive)
+		require.True(t, plan.Destructive())
+
+		s, err := js.Stream(t.Context(), "ORDERS")
+		require.NoError(t, err)
+		assert.Equal(t, int64(-1), s.CachedInfo().Config.MaxMsgs)
+
+		_, err = provision.Ensure(t.Context(), js, spec, provision.EnsureAllowDestructive())
+		require.NoError(t, err)
+		s, err = js.Stream(t.Context(), "ORDERS")
+		require.NoError(t, err)
+		assert.Equal(t, int64(100), s.CachedInfo().Config.MaxMsgs)
+	})
+	t.Run("recreate", func(t *testing.T) {
+		js := newJetStream(t)
+		_, err := provision.Ensure(t.Context(), js, testSpec())
+		require.NoError(t, err)
+
+		spec := testSpec()
+		spec.Streams[0].Storage = jetstream.MemoryStorage
+
+		plan, err := provision.Ensure(t.Context(), js, spec, provision.EnsureAllowDestructive())
+		require.NoError(t, err)
+		assert.Equal(t, provision.ActionRecreate, plan.Changes[1].Action)
+		assert.Equal(t, provision.ActionCreate, plan.Changes[2].Action)
+
+		s, err := js.Stream(t.Context(), "ORDERS")
+		require.NoError(t, err)
+		assert.Equal(t, jetstream.MemoryStorage, s.CachedInfo().Config.Storage)
+		_, err = s.Consumer(t.Context(), "billing")
+		require.NoError(t, err)
+	})
+	t.Run("dry run", func(t *testing.T) {
+		js := newJetStream(t)
+		out := new(bytes.Buffer)
+		plan, err := provision.Ensure(t.Context(), js, testSpec(), provision.EnsureDryRun(out))
+		require.NoError(t, err)
+		assert.True(t, plan.Pending())
+		assert.Equal(t, plan.String(), out.String())
+		assert.Contains(t, out.String(), "+ stream ORDERS (create)")
+
+		_, err = js.Stream(t.Context(), "ORDERS")
+		require.ErrorIs(t, err, jetstream.ErrStreamNotFound)
+
+		_, err = provision.Ensure(t.Context(), js, testSpec(), provision.EnsureDryRun(nil))
+		require.NoError(t, err)
+	})
+}
+
+func TestReadYAML(t *testing.T) {
+	const doc = `
+streams:
+  - name: ORDERS
+    subjects: ["orders.>"]
+    max_age: 1h
+    storage: memory
+    consumers:
+      - durable_name: billing
+        ack_policy: explicit
+        backoff: [1s, 5s]
+buckets:
+  - bucket: sessions
+    history: 5
+    ttl: 30m
+    LimitMarkerTTL: 1m
+`
+	spec, err := provision.ReadYAML(strings.NewReader(doc))
+	require.NoError(t, err)
+	require.Len(t, spec.Streams, 1)
+	assert.Equal(t, "ORDERS", spec.Streams[0].Name)
+	assert.Equal(t, time.Hour, spec.Streams[0].MaxAge)
+	assert.Equal(t, jetstream.MemoryStorage, spec.Streams[0].Storage)
+	require.Len(t, spec.Streams[0].Consumers, 1)
+	assert.Equal(t, jetstream.AckExplicitPolicy, spec.Streams[0].Consumers[0].AckPolicy)
+	assert.Equal(t, []time.Duration{time.Second, 5 * time.Second}, spec.Streams[0].Consumers[0].BackOff)
+	require.Len(t, spec.Buckets, 1)
+	assert.Equal(t, 30*time.Minute, spec.Buckets[0].TTL)
+	assert.Equal(t, time.Minute, spec.Buckets[0].LimitMarkerTTL)
+
+	_, err = provision.ReadYAML(strings.NewReader("streams:\n  - nmae: typo\n"))
+	require.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package provision
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// durationFields are the configuration fields of type time.Duration. In YAML
+// they may be written as Go duration strings, e.g. "24h" or "30s".
+var durationFields = map[string]bool{
+	"max_age":                   true,
+	"duplicate_window":          true,
+	"ttl":                       true,
+	"LimitMarkerTTL":            true,
+	"subject_delete_marker_ttl": true,
+	"ack_wait":                  true,
+	"backoff":                   true,
+	"max_expires":               true,
+	"inactive_threshold":        true,
+}
+
+// ReadYAML decodes a Spec from YAML. Field names follow the JetStream API
+// (the json tags of the jetstream configuration types), and unknown fields
+// are rejected.
+//
+//	streams:
+//	  - name: ORDERS
+//	    subjects: ["orders.>"]
+//	    max_age: 72h
+//	    consumers:
+//	      - durable_name: billing
+//	        ack_policy: explicit
+//	buckets:
+//	  - bucket: sessions
+//	    ttl: 1h
+func ReadYAML(r io.Reader) (Spec, error) {
+	var tree any
+	if err := yaml.NewDecoder(r).Decode(&tree); err != nil && err != io.EOF {
+		return Spec{}, err
+	}
+	tree, err := convertDurations(tree, false)
+	if err != nil {
+		return Spec{}, err
+	}
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return Spec{}, err
+	}
+	spec := Spec{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return Spec{}, err
+	}
+	return spec, nil
+}
+
+func convertDurations(x any, isDuration bool) (any, error) {
+	switch v := x.(type) {
+	case map[string]any:
+		for k, item := range v {
+			item, err := convertDurations(item, durationFields[k])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			v[k] = item
+		}
+	case []any:
+		for i, item := range v {
+			item, err := convertDurations(item, isDuration)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = item
+		}
+	case string:
+		if isDuration {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, err
+			}
+			return int64(d), nil
+		}
+	}
+	return x, nil
+}
@@ -0,0 +1,219 @@
+package publisher
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/mikluko/peanats"
+)
+
+// RawJetstreamAsyncPublisher is the dependency interface for asynchronous
+// JetStream publishing. jetstream.JetStream satisfies this via structural typing.
+//
+// Acks that never arrive are only timed out if the JetStream context was
+// created with jetstream.WithPublishAsyncTimeout.
+type RawJetstreamAsyncPublisher interface {
+	PublishMsgAsync(*nats.Msg, ...jetstream.PublishOpt) (jetstream.PubAckFuture, error)
+}
+
+// PubAckFuture is the pending result of an asynchronous publish.
+type PubAckFuture interface {
+	// Ack waits for the server acknowledgment or the publish failure.
+	Ack(context.Context) (*PubAck, error)
+	// Done is closed once the result is known.
+	Done() <-chan struct{}
+	// Msg returns the message as it was sent, e.g. to publish it again.
+	Msg() peanats.Msg
+}
+
+// AsyncErrorHandler is called for every message that failed to be stored.
+// It may publish the message again.
+type AsyncErrorHandler func(peanats.Msg, error)
+
+type JetstreamAsyncOption func(*jetstreamAsyncParams)
+
+type jetstreamAsyncParams struct {
+	maxInFlight int
+	errHandler  AsyncErrorHandler
+}
+
+const DefaultAsyncMaxInFlight = 4000
+
+// JetstreamAsyncMaxInFlight caps the number of unacknowledged publishes.
+// PublishAsync blocks while the cap is reached. Values below 1 are treated
+// as 1.
+func JetstreamAsyncMaxInFlight(n int) JetstreamAsyncOption {
+	return func(p *jetstreamAsyncParams) {
+		p.maxInFlight = max(n, 1)
+	}
+}
+
+// JetstreamAsyncErrorHandler sets the handler for failed publishes.
+func JetstreamAsyncErrorHandler(h AsyncErrorHandler) JetstreamAsyncOption {
+	return func(p *jetstreamAsyncParams) {
+		p.errHandler = h
+	}
+}
+
+// AsyncPublisher publishes messages to streams without waiting for the ack.
+type AsyncPublisher interface {
+	PublishAsync(context.Context, string, any, ...PublishOption) (PubAckFuture, error)
+	// PublishMsgAsync publishes a message that is already encoded. Only the
+	// JetStream specific options apply; header and content options are ignored.
+	PublishMsgAsync(context.Context, peanats.Msg, ...PublishOption) (PubAckFuture, error)
+	// Flush waits until every publish made so far is resolved.
+	Flush(context.Context) error
+	// Complete returns a channel closed once no publish is in flight.
+	Complete() <-chan struct{}
+	// InFlight returns the number of unresolved publishes.
+	InFlight() int
+}
+
+func NewJetstreamAsync(js RawJetstreamAsyncPublisher, opts ...JetstreamAsyncOption) AsyncPublisher {
+	p := jetstreamAsyncParams{
+		maxInFlight: DefaultAsyncMaxInFlight,
+	}
+	for _, o := range opts {
+		o(&p)
+	}
+	return &asyncImpl{
+		js:     js,
+		params: p,
+		sem:    make(chan struct{}, p.maxInFlight),
+	}
+}
+
+type asyncImpl struct {
+	js     RawJetstreamAsyncPublisher
+	params jetstreamAsyncParams
+	sem    chan struct{}
+
+	mu      sync.Mutex
+	pending int
+	done    chan struct{}
+}
+
+func (i *asyncImpl) PublishAsync(ctx context.Context, subj string, v any, opts ...PublishOption) (PubAckFuture, error) {
+	p, data, err := marshal(v, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return i.publish(ctx, &nats.Msg{Subject: subj, Header: nats.Header(p.Header), Data: data}, p.js)
+}
+
+func (i *asyncImpl) PublishMsgAsync(ctx context.Context, m peanats.Msg, opts ...PublishOption) (PubAckFuture, error) {
+	p := PublishParams{}
+	for _, o := range opts {
+		o(&p)
+	}
+	return i.publish(ctx, natsMsg(m), p.js)
+}
+
+func (i *asyncImpl) publish(ctx context.Context, m *nats.Msg, opts []jetstream.PublishOpt) (PubAckFuture, error) {
+	select {
+	case i.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	i.add()
+	paf, err := i.js.PublishMsgAsync(m, opts...)
+	if err != nil {
+		<-i.sem
+		i.release()
+		return nil, err
+	}
+	f := &pubAckFuture{
+		msg:  msg{m.Subject, peanats.Header(m.Header), m.Data},
+		done: make(chan struct{}),
+	}
+	go i.wait(paf, f)
+	return f, nil
+}
+
+func (i *asyncImpl) wait(paf jetstream.PubAckFuture, f *pubAckFuture) {
+	select {
+	case ack := <-paf.Ok():
+		f.ack = pubAck(ack)
+	case err := <-paf.Err():
+		f.err = err
+	}
+	// the slot is given back before the handler runs so that it can publish
+	// again, while the pending count holds until it returns
+	<-i.sem
+	if f.err != nil && i.params.errHandler != nil {
+		i.params.errHandler(f.msg, f.err)
+	}
+	close(f.done)
+	i.release()
+}
+
+func (i *asyncImpl) add() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.pending == 0 {
+		i.done = make(chan struct{})
+	}
+	i.pending++
+}
+
+func (i *asyncImpl) release() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.pending--
+	if i.pending == 0 {
+		close(i.done)
+	}
+}
+
+func (i *asyncImpl) Flush(ctx context.Context) error {
+	select {
+	case <-i.Complete():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (i *asyncImpl) Complete() <-chan struct{} {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.pending == 0 {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	return i.done
+}
+
+func (i *asyncImpl) InFlight() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.pending
+}
+
+type pubAckFuture struct {
+	msg  msg
+	done chan struct{}
+	ack  *PubAck
+	err  error
+}
+
+func (f *pubAckFuture) Ack(ctx context.Context) (*PubAck, error) {
+	select {
+	case <-f.done:
+		return f.ack, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (f *pubAckFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *pubAckFuture) Msg() peanats.Msg {
+	return f.msg
+}
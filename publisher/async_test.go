@@ -0,0 +1,153 @@
+package publisher_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/codec"
+	"github.com/mikluko/peanats/publisher"
+)
+
+func TestAsyncPublisher(t *testing.T) {
+	t.Run("futures", func(t *testing.T) {
+		js := setupJetstreamTest(t)
+		p := publisher.NewJetstreamAsync(js)
+
+		futures := make([]publisher.PubAckFuture, 100)
+		for i := range futures {
+			f, err := p.PublishAsync(t.Context(), fmt.Sprintf("orders.%d", i), &testPayload{Seq: i},
+				publisher.WithContentType(codec.Msgpack))
+			require.NoError(t, err)
+			futures[i] = f
+		}
+		require.NoError(t, p.Flush(t.Context()))
+		assert.Zero(t, p.InFlight())
+
+		seen := make(map[uint64]bool)
+		for i, f := range futures {
+			select {
+			case <-f.Done():
+			default:
+				t.Fatal("future not resolved after flush")
+			}
+			ack, err := f.Ack(t.Context())
+			require.NoError(t, err)
+			assert.Equal(t, "orders", ack.Stream)
+			seen[ack.Sequence] = true
+			assert.Equal(t, fmt.Sprintf("orders.%d", i), f.Msg().Subject())
+			assert.Equal(t, codec.Msgpack.String(), f.Msg().Header().Get(codec.HeaderContentType))
+		}
+		assert.Len(t, seen, 100)
+	})
+	t.Run("error handler", func(t *testing.T) {
+		js := setupJetstreamTest(t)
+
+		var (
+			mu     sync.Mutex
+			failed []peanats.Msg
+		)
+		p := publisher.NewJetstreamAsync(js, publisher.JetstreamAsyncErrorHandler(func(m peanats.Msg, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			failed = append(failed, m)
+		}))
+
+		f1, err := p.PublishAsync(t.Context(), "orders.1", nil, publisher.WithExpectedLastSubjectSequence(0))
+		require.NoError(t, err)
+		_, err = f1.Ack(t.Context())
+		require.NoError(t, err)
+
+		f2, err := p.PublishAsync(t.Context(), "orders.1", nil, publisher.WithExpectedLastSubjectSequence(0))
+		require.NoError(t, err)
+		_, err = f2.Ack(t.Context())
+		require.Error(t, err)
+
+		require.NoError(t, p.Flush(t.Context()))
+		mu.Lock()
+		defer mu.Unlock()
+		require.Len(t, failed, 1)
+		assert.Equal(t, "orders.1", failed[0].Subject())
+	})
+	t.Run("complete", func(t *testing.T) {
+		p := publisher.NewJetstreamAsync(&fakeAsync{})
+		select {
+		case <-p.Complete():
+		default:
+			t.Fatal("idle publisher must be complete")
+		}
+	})
+	t.Run("max in flight", func(t *testing.T) {
+		raw := &fakeAsync{}
+		p := publisher.NewJetstreamAsync(raw, publisher.JetstreamAsyncMaxInFlight(2))
+
+		_, err := p.PublishAsync(t.Context(), "orders.1", nil)
+		require.NoError(t, err)
+		_, err = p.PublishAsync(t.Context(), "orders.2", nil)
+		require.NoError(t, err)
+		assert.Equal(t, 2, p.InFlight())
+
+		ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+		defer cancel()
+		_, err = p.PublishAsync(ctx, "orders.3", nil)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.ErrorIs(t, p.Flush(ctx), context.DeadlineExceeded)
+
+		raw.ackFirst()
+		_, err = p.PublishAsync(t.Context(), "orders.3", nil)
+		require.NoError(t, err)
+
+		raw.ackFirst()
+		raw.ackFirst()
+		require.NoError(t, p.Flush(t.Context()))
+	})
+	t.Run("max in flight below one", func(t *testing.T) {
+		raw := &fakeAsync{}
+		p := publisher.NewJetstreamAsync(raw, publisher.JetstreamAsyncMaxInFlight(0))
+
+		_, err := p.PublishAsync(t.Context(), "orders.1", nil)
+		require.NoError(t, err)
+		assert.Equal(t, 1, p.InFlight())
+
+		raw.ackFirst()
+		require.NoError(t, p.Flush(t.Context()))
+	})
+}
+
+type fakeAsync struct {
+	mu      sync.Mutex
+	pending []*fakeFuture
+}
+
+func (f *fakeAsync) PublishMsgAsync(m *nats.Msg, _ ...jetstream.PublishOpt) (jetstream.PubAckFuture, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	paf := &fakeFuture{msg: m, ok: make(chan *jetstream.PubAck, 1), err: make(chan error, 1)}
+	f.pending = append(f.pending, paf)
+	return paf, nil
+}
+
+func (f *fakeAsync) ackFirst() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pending[0].ok <- &jetstream.PubAck{Stream: "orders"}
+	f.pending = f.pending[1:]
+}
+
+type fakeFuture struct {
+	msg *nats.Msg
+	ok  chan *jetstream.PubAck
+	err chan error
+}
+
+func (f *fakeFuture) Ok() <-chan *jetstream.PubAck { return f.ok }
+func (f *fakeFuture) Err() <-chan error            { return f.err }
+func (f *fakeFuture) Msg() *nats.Msg               { return f.msg }
@@ -0,0 +1,150 @@
+package publisher
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/mikluko/peanats"
+)
+
+// RawJetstreamPublisher is the dependency interface for JetStream publishing.
+// jetstream.JetStream satisfies this via structural typing.
+type RawJetstreamPublisher interface {
+	PublishMsg(context.Context, *nats.Msg, ...jetstream.PublishOpt) (*jetstream.PubAck, error)
+}
+
+// PubAck is the server acknowledgment of a message stored in a stream.
+type PubAck struct {
+	Stream    string
+	Sequence  uint64
+	Duplicate bool
+	Domain    string
+}
+
+// WithMsgID sets the Nats-Msg-Id header used by the server for deduplication
+// within the stream duplicate window. JetStream publishers only.
+func WithMsgID(id string) PublishOption {
+	return func(p *PublishParams) {
+		p.js = append(p.js, jetstream.WithMsgID(id))
+	}
+}
+
+// WithExpectedStream rejects the message unless it is stored in the named
+// stream. JetStream publishers only.
+func WithExpectedStream(stream string) PublishOption {
+	return func(p *PublishParams) {
+		p.js = append(p.js, jetstream.WithExpectStream(stream))
+	}
+}
+
+// WithExpectedLastSequence rejects the message unless the last sequence of the
+// stream equals seq. JetStream publishers only.
+func WithExpectedLastSequence(seq uint64) PublishOption {
+	return func(p *PublishParams) {
+		p.js = append(p.js, jetstream.WithExpectLastSequence(seq))
+	}
+}
+
+// WithExpectedLastSubjectSequence rejects the message unless the last sequence
+// stored for its subject equals seq. Zero means no message for the subject may
+// exist yet. JetStream publishers only.
+func WithExpectedLastSubjectSequence(seq uint64) PublishOption {
+	return func(p *PublishParams) {
+		p.js = append(p.js, jetstream.WithExpectLastSequencePerSubject(seq))
+	}
+}
+
+// WithExpectedLastMsgID rejects the message unless the last message in the
+// stream was published with the given Nats-Msg-Id. JetStream publishers only.
+func WithExpectedLastMsgID(id string) PublishOption {
+	return func(p *PublishParams) {
+		p.js = append(p.js, jetstream.WithExpectLastMsgID(id))
+	}
+}
+
+type JetstreamOption func(*jetstreamParams)
+
+type jetstreamParams struct {
+	opts []jetstream.PublishOpt
+}
+
+// JetstreamRetryAttempts sets how many times a publish is retried when no
+// stream responds, e.g. during a leader election. Negative retries forever.
+func JetstreamRetryAttempts(n int) JetstreamOption {
+	return func(p *jetstreamParams) {
+		p.opts = append(p.opts, jetstream.WithRetryAttempts(n))
+	}
+}
+
+// JetstreamRetryWait sets the pause between retries.
+func JetstreamRetryWait(d time.Duration) JetstreamOption {
+	return func(p *jetstreamParams) {
+		p.opts = append(p.opts, jetstream.WithRetryWait(d))
+	}
+}
+
+// JetstreamPublisher publishes messages to streams and waits for the ack.
+type JetstreamPublisher interface {
+	Publish(context.Context, string, any, ...PublishOption) (*PubAck, error)
+	// PublishMsg publishes a message that is already encoded. Only the
+	// JetStream specific options apply; header and content options are ignored.
+	PublishMsg(context.Context, peanats.Msg, ...PublishOption) (*PubAck, error)
+}
+
+func NewJetstream(js RawJetstreamPublisher, opts ...JetstreamOption) JetstreamPublisher {
+	p := jetstreamParams{}
+	for _, o := range opts {
+		o(&p)
+	}
+	return &jetstreamImpl{js: js, params: p}
+}
+
+type jetstreamImpl struct {
+	js     RawJetstreamPublisher
+	params jetstreamParams
+}
+
+func (i *jetstreamImpl) Publish(ctx context.Context, subj string, v any, opts ...PublishOption) (*PubAck, error) {
+	p, data, err := marshal(v, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return i.publish(ctx, &nats.Msg{Subject: subj, Header: nats.Header(p.Header), Data: data}, p.js)
+}
+
+func (i *jetstreamImpl) PublishMsg(ctx context.Context, m peanats.Msg, opts ...PublishOption) (*PubAck, error) {
+	p := PublishParams{}
+	for _, o := range opts {
+		o(&p)
+	}
+	return i.publish(ctx, natsMsg(m), p.js)
+}
+
+// natsMsg copies the header, as the jetstream client adds its own entries to it.
+func natsMsg(m peanats.Msg) *nats.Msg {
+	header := make(nats.Header, len(m.Header()))
+	for k, v := range m.Header() {
+		header[k] = v
+	}
+	return &nats.Msg{Subject: m.Subject(), Header: header, Data: m.Data()}
+}
+
+func (i *jetstreamImpl) publish(ctx context.Context, m *nats.Msg, opts []jetstream.PublishOpt) (*PubAck, error) {
+	ack, err := i.js.PublishMsg(ctx, m, append(i.params.opts[:len(i.params.opts):len(i.params.opts)], opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return pubAck(ack), nil
+}
+
+func pubAck(ack *jetstream.PubAck) *PubAck {
+	return &PubAck{
+		Stream:    ack.Stream,
+		Sequence:  ack.Sequence,
+		Duplicate: ack.Duplicate,
+		Domain:    ack.Domain,
+	}
+}
@@ -0,0 +1,128 @@
+package publisher_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/codec"
+	"github.com/mikluko/peanats/internal/xtestutil"
+	"github.com/mikluko/peanats/publisher"
+)
+
+func setupJetstreamTest(t *testing.T) jetstream.JetStream {
+	js := xtestutil.JetStream(t, xtestutil.Server(t))
+	xtestutil.Stream(t, js, jetstream.StreamConfig{
+		Name:     "orders",
+		Subjects: []string{"orders.>"},
+	})
+	return js
+}
+
+func TestJetstreamPublisher(t *testing.T) {
+	t.Run("ack", func(t *testing.T) {
+		js := setupJetstreamTest(t)
+		p := publisher.NewJetstream(js)
+
+		ack, err := p.Publish(t.Context(), "orders.1", &testPayload{Seq: 1, Str: "hello"},
+			publisher.WithContentType(codec.Msgpack),
+			publisher.WithContentEncoding(codec.Zstd),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, &publisher.PubAck{Stream: "orders", Sequence: 1}, ack)
+
+		raw, err := js.Stream(t.Context(), "orders")
+		require.NoError(t, err)
+		m, err := raw.GetMsg(t.Context(), 1)
+		require.NoError(t, err)
+		assert.Equal(t, codec.Msgpack.String(), m.Header.Get(codec.HeaderContentType))
+		var v testPayload
+		require.NoError(t, codec.UnmarshalHeader(m.Data, &v, peanats.Header(m.Header)))
+		assert.Equal(t, testPayload{Seq: 1, Str: "hello"}, v)
+	})
+	t.Run("deduplication", func(t *testing.T) {
+		js := setupJetstreamTest(t)
+		p := publisher.NewJetstream(js)
+
+		ack, err := p.Publish(t.Context(), "orders.1", &testPayload{Seq: 1}, publisher.WithMsgID("order-1"))
+		require.NoError(t, err)
+		assert.False(t, ack.Duplicate)
+
+		ack, err = p.Publish(t.Context(), "orders.1", &testPayload{Seq: 1}, publisher.WithMsgID("order-1"))
+		require.NoError(t, err)
+		assert.True(t, ack.Duplicate)
+		assert.Equal(t, uint64(1), ack.Sequence)
+	})
+	t.Run("expected last subject sequence", func(t *testing.T) {
+		js := setupJetstreamTest(t)
+		p := publisher.NewJetstream(js)
+
+		ack, err := p.Publish(t.Context(), "orders.1", &testPayload{Seq: 1}, publisher.WithExpectedLastSubjectSequence(0))
+		require.NoError(t, err)
+		_, err = p.Publish(t.Context(), "orders.2", &testPayload{Seq: 1}, publisher.WithExpectedLastSubjectSequence(0))
+		require.NoError(t, err)
+
+		_, err = p.Publish(t.Context(), "orders.1", &testPayload{Seq: 2}, publisher.WithExpectedLastSubjectSequence(0))
+		require.Error(t, err)
+		var apiErr *jetstream.APIError
+		require.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, jetstream.JSErrCodeStreamWrongLastSequence, apiErr.ErrorCode)
+
+		_, err = p.Publish(t.Context(), "orders.1", &testPayload{Seq: 2}, publisher.WithExpectedLastSubjectSequence(ack.Sequence))
+		require.NoError(t, err)
+	})
+	t.Run("expected last sequence and stream", func(t *testing.T) {
+		js := setupJetstreamTest(t)
+		p := publisher.NewJetstream(js)
+
+		_, err := p.Publish(t.Context(), "orders.1", nil, publisher.WithExpectedStream("payments"))
+		require.Error(t, err)
+		_, err = p.Publish(t.Context(), "orders.1", nil,
+			publisher.WithExpectedStream("orders"), publisher.WithExpectedLastSequence(0))
+		require.NoError(t, err)
+		_, err = p.Publish(t.Context(), "orders.1", nil, publisher.WithExpectedLastSequence(0))
+		require.Error(t, err)
+	})
+	t.Run("publish msg", func(t *testing.T) {
+		js := setupJetstreamTest(t)
+		p := publisher.NewJetstream(js)
+
+		h := peanats.Header{codec.HeaderContentType: []string{codec.JSON.String()}}
+		m := peanats.NewMsg(&nats.Msg{Subject: "orders.1", Header: nats.Header(h), Data: []byte(`{}`)})
+		ack, err := p.PublishMsg(t.Context(), m, publisher.WithMsgID("order-1"))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), ack.Sequence)
+		assert.Empty(t, h.Get(jetstream.MsgIDHeader), "caller header must not be modified")
+	})
+	t.Run("retry on no responders", func(t *testing.T) {
+		js := xtestutil.JetStream(t, xtestutil.Server(t))
+
+		// the stream shows up while the publisher keeps retrying
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			_, _ = js.CreateStream(context.Background(), jetstream.StreamConfig{
+				Name:     "late",
+				Subjects: []string{"late.>"},
+			})
+		}()
+
+		p := publisher.NewJetstream(js,
+			publisher.JetstreamRetryAttempts(50),
+			publisher.JetstreamRetryWait(20*time.Millisecond),
+		)
+		ack, err := p.Publish(t.Context(), "late.1", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "late", ack.Stream)
+
+		p = publisher.NewJetstream(js, publisher.JetstreamRetryAttempts(0))
+		_, err = p.Publish(t.Context(), "missing.1", nil)
+		require.ErrorIs(t, err, jetstream.ErrNoStreamResponse)
+	})
+}
@@ -3,6 +3,8 @@ package publisher
 import (
 	"context"
 
+	"github.com/nats-io/nats.go/jetstream"
+
 	"github.com/mikluko/peanats"
 	"github.com/mikluko/peanats/codec"
 )
@@ -19,6 +21,9 @@ type PublishParams struct {
 	Header          peanats.Header
 	ContentType     codec.ContentType
 	ContentEncoding codec.ContentEncoding
+
+	// jetstream publish options, ignored by core publishers
+	js []jetstream.PublishOpt
 }
 
 // WithHeader sets the header for the message.
@@ -56,6 +61,24 @@ type publisherImpl struct {
 }
 
 func (i *publisherImpl) Publish(ctx context.Context, subj string, v any, opts ...PublishOption) error {
+	p, data, err := marshal(v, opts...)
+	if err != nil {
+		return err
+	}
+	return i.pub.Publish(ctx, msg{subj, p.Header, data})
+}
+
+// Marshal encodes v exactly as Publish does and returns the resulting header
+// and payload, e.g. to store the message for later delivery.
+func Marshal(v any, opts ...PublishOption) (peanats.Header, []byte, error) {
+	p, data, err := marshal(v, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p.Header, data, nil
+}
+
+func marshal(v any, opts ...PublishOption) (PublishParams, []byte, error) {
 	p := PublishParams{
 		Header:      make(peanats.Header),
 		ContentType: codec.JSON,
@@ -69,9 +92,9 @@ func (i *publisherImpl) Publish(ctx context.Context, subj string, v any, opts ..
 	}
 	data, err := codec.MarshalHeader(v, p.Header)
 	if err != nil {
-		return err
+		return p, nil, err
 	}
-	return i.pub.Publish(ctx, msg{subj, p.Header, data})
+	return p, data, nil
 }
 
 type msg struct {
@@ -0,0 +1,299 @@
+package requester
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/codec"
+)
+
+// HeaderCacheControl is set by responders to make replies cacheable, e.g.
+// "max-age=60". The "no-store" and "no-cache" directives disable caching.
+const HeaderCacheControl = "Cache-Control"
+
+// CacheEntry is an encoded reply kept by a CacheStore.
+type CacheEntry struct {
+	Header  peanats.Header `json:"header,omitempty"`
+	Data    []byte         `json:"data,omitempty"`
+	Expires time.Time      `json:"expires"`
+}
+
+// CacheStore keeps cached replies. Get returns nil on a miss.
+type CacheStore interface {
+	Get(ctx context.Context, key string) (*CacheEntry, error)
+	Put(ctx context.Context, key string, e *CacheEntry) error
+}
+
+// CacheErrorHandler is called with errors of the store, which the cache
+// recovers from by passing requests through.
+type CacheErrorHandler func(error)
+
+type CacheOption func(*cacheParams)
+
+type cacheParams struct {
+	store   CacheStore
+	headers []string
+	maxAge  time.Duration
+	onError CacheErrorHandler
+}
+
+// DefaultCacheSize is the capacity of the in-memory store used unless
+// CacheStorage is given.
+const DefaultCacheSize = 1024
+
+// CacheStorage sets the store of cached replies. The default is an in-memory
+// LRU store of DefaultCacheSize entries.
+func CacheStorage(s CacheStore) CacheOption {
+	return func(p *cacheParams) {
+		p.store = s
+	}
+}
+
+// CacheKeyHeaders adds the values of the named request headers to the cache
+// key. By default only the subject and the encoded request make up the key.
+func CacheKeyHeaders(names ...string) CacheOption {
+	return func(p *cacheParams) {
+		p.headers = append(p.headers, names...)
+	}
+}
+
+// CacheDefaultMaxAge sets how long replies without the Cache-Control header
+// are cached. By default they are not cached.
+func CacheDefaultMaxAge(d time.Duration) CacheOption {
+	return func(p *cacheParams) {
+		p.maxAge = d
+	}
+}
+
+// CacheOnError sets the handler of store errors. By default they are dropped.
+func CacheOnError(h CacheErrorHandler) CacheOption {
+	return func(p *cacheParams) {
+		p.onError = h
+	}
+}
+
+// NewCache wraps r with a cache of Request replies. Replies are cached for
+// the max-age announced by the responder. Concurrent identical requests are
+// coalesced into one; they share the context of the first caller. A failing
+// store does not fail requests: reads count as misses and replies that can
+// not be stored are returned all the same. Streaming responses, gathers and
+// sessions are passed through.
+func NewCache[RQ, RS any](r Requester[RQ, RS], opts ...CacheOption) Requester[RQ, RS] {
+	p := cacheParams{onError: func(error) {}}
+	for _, o := range opts {
+		o(&p)
+	}
+	if p.store == nil {
+		p.store = NewMemoryCacheStore(DefaultCacheSize)
+	}
+	return &cacheImpl[RQ, RS]{Requester: r, params: p}
+}
+
+type cacheImpl[RQ, RS any] struct {
+	Requester[RQ, RS]
+	params cacheParams
+	group  singleflight.Group
+}
+
+func (c *cacheImpl[RQ, RS]) Request(ctx context.Context, subj string, rq *RQ, opts ...RequestOption) (Response[RS], error) {
+	key, err := c.key(subj, rq, opts...)
+	if err != nil {
+		return nil, err
+	}
+	e, err := c.params.store.Get(ctx, key)
+	if err != nil {
+		c.params.onError(err)
+		e = nil
+	}
+	if e == nil || !time.Now().Before(e.Expires) {
+		v, err, _ := c.group.Do(key, func() (any, error) {
+			return c.fetch(ctx, key, subj, rq, opts...)
+		})
+		if err != nil {
+			return nil, err
+		}
+		e = v.(*CacheEntry)
+	}
+	rs := new(RS)
+	if err := codec.UnmarshalHeader(e.Data, rs, e.Header); err != nil {
+		return nil, err
+	}
+	return &responseImpl[RS]{header: peanats.CloneHeader(e.Header), payload: rs}, nil
+}
+
+// fetch sends the request and stores the reply if it is cacheable. The
+// reply is returned encoded, so that every coalesced caller decodes its own
+// copy.
+func (c *cacheImpl[RQ, RS]) fetch(ctx context.Context, key, subj string, rq *RQ, opts ...RequestOption) (*CacheEntry, error) {
+	res, err := c.Requester.Request(ctx, subj, rq, opts...)
+	if err != nil {
+		return nil, err
+	}
+	h := peanats.CloneHeader(res.Header())
+	data, err := codec.MarshalHeader(res.Value(), h)
+	if err != nil {
+		return nil, err
+	}
+	e := &CacheEntry{Header: h, Data: data}
+	maxAge, ok := cacheMaxAge(h)
+	if !ok {
+		maxAge = c.params.maxAge
+	}
+	if maxAge > 0 {
+		e.Expires = time.Now().Add(maxAge)
+		if err := c.params.store.Put(ctx, key, e); err != nil {
+			c.params.onError(err)
+		}
+	}
+	return e, nil
+}
+
+func (c *cacheImpl[RQ, RS]) key(subj string, rq *RQ, opts ...RequestOption) (string, error) {
+	p := makeRequestParams(opts...)
+	data, err := codec.MarshalHeader(rq, p.header)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(subj))
+	h.Write([]byte{0})
+	h.Write(data)
+	for _, name := range c.params.headers {
+		h.Write([]byte{0})
+		h.Write([]byte(name))
+		for _, v := range p.header.Values(name) {
+			h.Write([]byte{0})
+			h.Write([]byte(v))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cacheMaxAge reads the max-age directive. The second result is false when
+// the header is absent.
+func cacheMaxAge(h peanats.Header) (time.Duration, bool) {
+	v := h.Get(HeaderCacheControl)
+	if v == "" {
+		return 0, false
+	}
+	var maxAge time.Duration
+	for _, d := range strings.Split(v, ",") {
+		d = strings.TrimSpace(d)
+		switch {
+		case d == "no-store" || d == "no-cache":
+			return 0, true
+		case strings.HasPrefix(d, "max-age="):
+			n, err := strconv.ParseUint(strings.TrimPrefix(d, "max-age="), 10, 32)
+			if err == nil {
+				maxAge = time.Duration(n) * time.Second
+			}
+		}
+	}
+	return maxAge, true
+}
+
+// NewMemoryCacheStore creates an in-memory store keeping up to size entries,
+// evicting the least recently used ones.
+func NewMemoryCacheStore(size int) CacheStore {
+	return &memoryCacheStore{
+		size:  max(size, 1),
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+type memoryCacheStore struct {
+	mu    sync.Mutex
+	size  int
+	items map[string]*list.Element
+	order *list.List
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry *CacheEntry
+}
+
+func (s *memoryCacheStore) Get(_ context.Context, key string) (*CacheEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	item := el.Value.(*memoryCacheItem)
+	if !time.Now().Before(item.entry.Expires) {
+		s.order.Remove(el)
+		delete(s.items, key)
+		return nil, nil
+	}
+	s.order.MoveToFront(el)
+	return item.entry, nil
+}
+
+func (s *memoryCacheStore) Put(_ context.Context, key string, e *CacheEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[key]; ok {
+		el.Value.(*memoryCacheItem).entry = e
+		s.order.MoveToFront(el)
+		return nil
+	}
+	s.items[key] = s.order.PushFront(&memoryCacheItem{key: key, entry: e})
+	for s.order.Len() > s.size {
+		el := s.order.Back()
+		s.order.Remove(el)
+		delete(s.items, el.Value.(*memoryCacheItem).key)
+	}
+	return nil
+}
+
+// NewKeyValueCacheStore creates a store sharing cached replies through a
+// JetStream key-value bucket. Expired entries are ignored but not removed;
+// set a TTL on the bucket to purge them.
+func NewKeyValueCacheStore(kv jetstream.KeyValue) CacheStore {
+	return &kvCacheStore{kv: kv}
+}
+
+type kvCacheStore struct {
+	kv jetstream.KeyValue
+}
+
+func (s *kvCacheStore) Get(ctx context.Context, key string) (*CacheEntry, error) {
+	ent, err := s.kv.Get(ctx, key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	e := new(CacheEntry)
+	if err := json.Unmarshal(ent.Value(), e); err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(e.Expires) {
+		return nil, nil
+	}
+	return e, nil
+}
+
+func (s *kvCacheStore) Put(ctx context.Context, key string, e *CacheEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.kv.Put(ctx, key, data)
+	return err
+}
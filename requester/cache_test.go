@@ -0,0 +1,186 @@
+package requester
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	natsrv "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/internal/xtestutil"
+)
+
+func TestRequester_Cache(t *testing.T) {
+	type request struct {
+		Key string `json:"key"`
+	}
+	type response struct {
+		Key   string `json:"key"`
+		Calls int64  `json:"calls"`
+	}
+
+	setup := func(t *testing.T, cacheControl string, delay time.Duration) (Requester[request, response], *atomic.Int64, *natsrv.Server) {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		calls := new(atomic.Int64)
+		argh := peanats.ArgHandlerFunc[request](func(ctx context.Context, arg peanats.Arg[request]) error {
+			n := calls.Add(1)
+			time.Sleep(delay)
+			h := peanats.Header{}
+			if cacheControl != "" {
+				h.Set(HeaderCacheControl, cacheControl)
+			}
+			return arg.(peanats.Respondable).RespondHeader(ctx, &response{Key: arg.Value().Key, Calls: n}, h)
+		})
+		sub, err := nc.SubscribeHandler(t.Context(), "cache.query", peanats.MsgHandlerFromArgHandler(argh))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = sub.Unsubscribe() })
+		return New[request, response](nc), calls, ns
+	}
+
+	t.Run("max age", func(t *testing.T) {
+		r, calls, _ := setup(t, "max-age=60", 0)
+		c := NewCache(r)
+		for i := 0; i < 3; i++ {
+			rs, err := c.Request(t.Context(), "cache.query", &request{Key: "a"})
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), rs.Value().Calls)
+			assert.Equal(t, "max-age=60", rs.Header().Get(HeaderCacheControl))
+		}
+		rs, err := c.Request(t.Context(), "cache.query", &request{Key: "b"})
+		require.NoError(t, err)
+		assert.Equal(t, "b", rs.Value().Key)
+		assert.Equal(t, int64(2), calls.Load())
+	})
+	t.Run("not cacheable", func(t *testing.T) {
+		for _, cc := range []string{"", "no-store"} {
+			r, calls, _ := setup(t, cc, 0)
+			c := NewCache(r)
+			for i := 0; i < 3; i++ {
+				_, err := c.Request(t.Context(), "cache.query", &request{Key: "a"})
+				require.NoError(t, err)
+			}
+			assert.Equal(t, int64(3), calls.Load(), cc)
+		}
+	})
+	t.Run("default max age", func(t *testing.T) {
+		r, calls, _ := setup(t, "", 0)
+		c := NewCache(r, CacheDefaultMaxAge(time.Minute))
+		for i := 0; i < 3; i++ {
+			_, err := c.Request(t.Context(), "cache.query", &request{Key: "a"})
+			require.NoError(t, err)
+		}
+		assert.Equal(t, int64(1), calls.Load())
+	})
+	t.Run("expiry", func(t *testing.T) {
+		r, calls, _ := setup(t, "max-age=1", 0)
+		c := NewCache(r)
+		_, err := c.Request(t.Context(), "cache.query", &request{Key: "a"})
+		require.NoError(t, err)
+		time.Sleep(1100 * time.Millisecond)
+		_, err = c.Request(t.Context(), "cache.query", &request{Key: "a"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), calls.Load())
+	})
+	t.Run("key headers", func(t *testing.T) {
+		r, calls, _ := setup(t, "max-age=60", 0)
+		c := NewCache(r, CacheKeyHeaders("X-Tenant"))
+		for _, tenant := range []string{"a", "b", "a", "b"} {
+			_, err := c.Request(t.Context(), "cache.query", &request{Key: "a"},
+				RequestHeader(peanats.Header{"X-Tenant": []string{tenant}}))
+			require.NoError(t, err)
+		}
+		assert.Equal(t, int64(2), calls.Load())
+	})
+	t.Run("coalesce", func(t *testing.T) {
+		r, calls, _ := setup(t, "", 100*time.Millisecond)
+		c := NewCache(r)
+		var wg sync.WaitGroup
+		values := make([]*response, 10)
+		for i := range values {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rs, err := c.Request(t.Context(), "cache.query", &request{Key: "a"})
+				if assert.NoError(t, err) {
+					values[i] = rs.Value()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int64(1), calls.Load())
+		// every caller gets its own copy
+		values[0].Key = "changed"
+		assert.Equal(t, "a", values[1].Key)
+	})
+	t.Run("failing store", func(t *testing.T) {
+		r, calls, _ := setup(t, "max-age=60", 0)
+		var errs atomic.Int64
+		c := NewCache(r, CacheStorage(failingCacheStore{}), CacheOnError(func(err error) {
+			assert.ErrorIs(t, err, errStoreDown)
+			errs.Add(1)
+		}))
+		for i := 1; i <= 2; i++ {
+			rs, err := c.Request(t.Context(), "cache.query", &request{Key: "a"})
+			require.NoError(t, err)
+			assert.Equal(t, int64(i), rs.Value().Calls)
+		}
+		assert.Equal(t, int64(2), calls.Load())
+		assert.Equal(t, int64(4), errs.Load())
+	})
+	t.Run("key value store", func(t *testing.T) {
+		r, calls, ns := setup(t, "max-age=60", 0)
+		js := xtestutil.JetStream(t, ns)
+		kv := xtestutil.KeyValue(t, js, jetstream.KeyValueConfig{Bucket: "cache"})
+
+		// two caches sharing the bucket
+		c1 := NewCache(r, CacheStorage(NewKeyValueCacheStore(kv)))
+		c2 := NewCache(r, CacheStorage(NewKeyValueCacheStore(kv)))
+		_, err := c1.Request(t.Context(), "cache.query", &request{Key: "a"})
+		require.NoError(t, err)
+		rs, err := c2.Request(t.Context(), "cache.query", &request{Key: "a"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), rs.Value().Calls)
+		assert.Equal(t, int64(1), calls.Load())
+	})
+}
+
+var errStoreDown = errors.New("store down")
+
+type failingCacheStore struct{}
+
+func (failingCacheStore) Get(context.Context, string) (*CacheEntry, error) {
+	return nil, errStoreDown
+}
+
+func (failingCacheStore) Put(context.Context, string, *CacheEntry) error {
+	return errStoreDown
+}
+
+func TestMemoryCacheStore(t *testing.T) {
+	s := NewMemoryCacheStore(2)
+	e := &CacheEntry{Expires: time.Now().Add(time.Minute)}
+	require.NoError(t, s.Put(t.Context(), "a", e))
+	require.NoError(t, s.Put(t.Context(), "b", e))
+	_, _ = s.Get(t.Context(), "a") // a is now the most recently used
+	require.NoError(t, s.Put(t.Context(), "c", e))
+
+	got, err := s.Get(t.Context(), "b")
+	require.NoError(t, err)
+	assert.Nil(t, got, "least recently used entry is evicted")
+	got, err = s.Get(t.Context(), "a")
+	require.NoError(t, err)
+	assert.NotNil(t, got)
+
+	require.NoError(t, s.Put(t.Context(), "d", &CacheEntry{Expires: time.Now().Add(-time.Second)}))
+	got, err = s.Get(t.Context(), "d")
+	require.NoError(t, err)
+	assert.Nil(t, got, "expired entry is a miss")
+}
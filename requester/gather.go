@@ -0,0 +1,162 @@
+package requester
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/codec"
+)
+
+type GatherOption func(*gatherParams)
+
+type gatherParams struct {
+	max    int
+	idle   time.Duration
+	buffer uint
+	rqOpts []RequestOption
+}
+
+const DefaultGatherBuffer = 64
+
+// GatherMax stops gathering once n replies were received.
+func GatherMax(n int) GatherOption {
+	return func(p *gatherParams) {
+		p.max = n
+	}
+}
+
+// GatherIdleTimeout stops gathering when no reply arrives within d after the
+// previous one. The timeout is not armed until the first reply arrives; the
+// context deadline bounds the wait for it.
+func GatherIdleTimeout(d time.Duration) GatherOption {
+	return func(p *gatherParams) {
+		p.idle = d
+	}
+}
+
+// GatherBuffer sets the buffer size for replies waiting to be decoded.
+// Replies that do not fit into the buffer are dropped by the client.
+func GatherBuffer(size uint) GatherOption {
+	return func(p *gatherParams) {
+		p.buffer = size
+	}
+}
+
+// GatherRequestOptions appends the set of request options for the request
+// produced by Gather.
+func GatherRequestOptions(opts ...RequestOption) GatherOption {
+	return func(p *gatherParams) {
+		p.rqOpts = append(p.rqOpts, opts...)
+	}
+}
+
+// GatherStop tells why gathering ended.
+type GatherStop uint8
+
+const (
+	// GatherStopMax means the requested number of replies was received.
+	GatherStopMax GatherStop = iota + 1
+	// GatherStopIdle means the idle timeout expired after the last reply.
+	GatherStopIdle
+	// GatherStopDeadline means the context deadline was reached.
+	GatherStopDeadline
+)
+
+func (s GatherStop) String() string {
+	switch s {
+	case GatherStopMax:
+		return "max"
+	case GatherStopIdle:
+		return "idle"
+	case GatherStopDeadline:
+		return "deadline"
+	default:
+		return "unknown"
+	}
+}
+
+// Gathered is the outcome of a Gather call.
+type Gathered[T any] struct {
+	// Responses holds the successfully decoded replies in arrival order.
+	Responses []Response[T]
+	// Errors holds one error per reply that could not be decoded.
+	Errors []error
+	Stop   GatherStop
+}
+
+// Err joins all reply errors, or returns nil if there were none.
+func (g *Gathered[T]) Err() error {
+	return errors.Join(g.Errors...)
+}
+
+// Gather publishes a single request and collects replies from any number of
+// responders. It stops at GatherMax replies, on GatherIdleTimeout or at the
+// context deadline, whichever comes first; these are not errors. A context
+// cancelled for any other reason aborts the call with the context error.
+// Empty replies are ignored.
+func (c *clientImpl[RQ, RS]) Gather(ctx context.Context, subj string, rq *RQ, opts ...GatherOption) (*Gathered[RS], error) {
+	p := gatherParams{
+		buffer: DefaultGatherBuffer,
+	}
+	for _, o := range opts {
+		o(&p)
+	}
+	buf, sub, err := c.inbox(ctx, subj, rq, p.buffer, nil, p.rqOpts...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = sub.Unsubscribe() }()
+
+	var (
+		g    = &Gathered[RS]{}
+		n    int
+		idle *time.Timer
+		tick <-chan time.Time
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				g.Stop = GatherStopDeadline
+				return g, nil
+			}
+			return nil, ctx.Err()
+		case <-tick:
+			g.Stop = GatherStopIdle
+			return g, nil
+		case msg := <-buf:
+			if len(msg.Data()) == 0 {
+				continue
+			}
+			n++
+			if rs, err := decode[RS](msg); err != nil {
+				g.Errors = append(g.Errors, err)
+			} else {
+				g.Responses = append(g.Responses, rs)
+			}
+			if p.max > 0 && n >= p.max {
+				g.Stop = GatherStopMax
+				return g, nil
+			}
+			if p.idle > 0 {
+				if idle == nil {
+					idle = time.NewTimer(p.idle)
+					defer idle.Stop()
+					tick = idle.C
+				} else {
+					idle.Reset(p.idle)
+				}
+			}
+		}
+	}
+}
+
+func decode[T any](msg peanats.Msg) (Response[T], error) {
+	x := new(T)
+	if err := codec.UnmarshalHeader(msg.Data(), x, msg.Header()); err != nil {
+		return nil, err
+	}
+	return &responseImpl[T]{header: msg.Header(), payload: x}, nil
+}
@@ -0,0 +1,106 @@
+package requester
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/codec"
+	"github.com/mikluko/peanats/internal/xtestutil"
+)
+
+func TestRequester_Gather(t *testing.T) {
+	type request struct {
+		Foo string `json:"foo"`
+	}
+	type response struct {
+		Shard int `json:"shard"`
+	}
+
+	setup := func(t *testing.T, shards int, delay time.Duration) Requester[request, response] {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		for i := 0; i < shards; i++ {
+			argh := peanats.ArgHandlerFunc[request](func(ctx context.Context, arg peanats.Arg[request]) error {
+				time.Sleep(time.Duration(i) * delay)
+				return arg.(peanats.Respondable).RespondHeader(ctx, &response{Shard: i},
+					peanats.Header{"X-Shard": []string{fmt.Sprint(i)}})
+			})
+			sub, err := nc.SubscribeHandler(t.Context(), "shards.query", peanats.MsgHandlerFromArgHandler(argh))
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = sub.Unsubscribe() })
+		}
+		return New[request, response](nc)
+	}
+
+	t.Run("max", func(t *testing.T) {
+		c := setup(t, 5, 0)
+		g, err := c.Gather(t.Context(), "shards.query", &request{Foo: "bar"}, GatherMax(5))
+		require.NoError(t, err)
+		assert.Equal(t, GatherStopMax, g.Stop)
+		require.Len(t, g.Responses, 5)
+		assert.NoError(t, g.Err())
+		seen := make(map[int]bool)
+		for _, rs := range g.Responses {
+			seen[rs.Value().Shard] = true
+			assert.Equal(t, fmt.Sprint(rs.Value().Shard), rs.Header().Get("X-Shard"))
+		}
+		assert.Len(t, seen, 5)
+	})
+	t.Run("idle", func(t *testing.T) {
+		c := setup(t, 3, 10*time.Millisecond)
+		g, err := c.Gather(t.Context(), "shards.query", &request{Foo: "bar"}, GatherIdleTimeout(200*time.Millisecond))
+		require.NoError(t, err)
+		assert.Equal(t, GatherStopIdle, g.Stop)
+		assert.Len(t, g.Responses, 3)
+	})
+	t.Run("deadline", func(t *testing.T) {
+		c := setup(t, 3, 0)
+		ctx, cancel := context.WithTimeout(t.Context(), 200*time.Millisecond)
+		defer cancel()
+		g, err := c.Gather(ctx, "shards.query", &request{Foo: "bar"}, GatherMax(10))
+		require.NoError(t, err)
+		assert.Equal(t, GatherStopDeadline, g.Stop)
+		assert.Len(t, g.Responses, 3)
+	})
+	t.Run("cancel", func(t *testing.T) {
+		c := setup(t, 0, 0)
+		ctx, cancel := context.WithCancel(t.Context())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		_, err := c.Gather(ctx, "shards.query", &request{Foo: "bar"})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+	t.Run("decode errors", func(t *testing.T) {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		for i, data := range []string{`{"shard":1}`, `{`} {
+			h := peanats.MsgHandlerFunc(func(ctx context.Context, m peanats.Msg) error {
+				return m.(peanats.Respondable).RespondMsg(ctx, &rawMsg{data: []byte(data)})
+			})
+			sub, err := nc.SubscribeHandler(t.Context(), "shards.query", h)
+			require.NoError(t, err, fmt.Sprint(i))
+			t.Cleanup(func() { _ = sub.Unsubscribe() })
+		}
+		c := New[request, response](nc)
+		g, err := c.Gather(t.Context(), "shards.query", &request{Foo: "bar"}, GatherMax(2))
+		require.NoError(t, err)
+		assert.Len(t, g.Responses, 1)
+		assert.Len(t, g.Errors, 1)
+		assert.Error(t, g.Err())
+	})
+}
+
+type rawMsg struct {
+	data []byte
+}
+
+func (m *rawMsg) Subject() string { return "" }
+func (m *rawMsg) Header() peanats.Header {
+	return peanats.Header{codec.HeaderContentType: []string{codec.JSON.String()}}
+}
+func (m *rawMsg) Data() []byte { return m.data }
@@ -0,0 +1,142 @@
+package requester
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/internal/xstream"
+	"github.com/mikluko/peanats/transport"
+)
+
+type RequesterOption func(*requesterParams)
+
+type requesterParams struct {
+	shared bool
+}
+
+// RequesterSharedInbox makes ResponseReceiver and Gather receive replies
+// through a single wildcard inbox subscription per requester instead of
+// subscribing a fresh inbox for every call. The subscription is created on
+// first use and lives as long as the connection.
+func RequesterSharedInbox() RequesterOption {
+	return func(p *requesterParams) {
+		p.shared = true
+	}
+}
+
+// sharedInbox demultiplexes replies received on <prefix>.* by the last
+// subject token into per-request channels.
+type sharedInbox struct {
+	nc transport.Conn
+
+	mu     sync.Mutex
+	prefix string
+	sub    transport.Unsubscriber
+	routes map[string]*inboxRoute
+}
+
+func newSharedInbox(nc transport.Conn) *sharedInbox {
+	return &sharedInbox{nc: nc, routes: make(map[string]*inboxRoute)}
+}
+
+// open registers a route delivering to ch and returns its reply subject.
+// With a non-nil beat, heartbeats are signaled there instead.
+func (s *sharedInbox) open(ctx context.Context, ch chan peanats.Msg, beat chan struct{}) (string, transport.Unsubscriber, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sub == nil {
+		prefix := nats.NewInbox()
+		sub, err := s.nc.SubscribeHandler(context.Background(), prefix+".*", peanats.MsgHandlerFunc(s.deliver),
+			transport.SubscribeHandlerDispatcher(xstream.InlineDispatcher{}))
+		if err != nil {
+			return "", nil, err
+		}
+		s.prefix, s.sub = prefix, sub
+	}
+	token := nuid.Next()
+	r := &inboxRoute{
+		in:   make(chan peanats.Msg, cap(ch)),
+		beat: beat,
+		done: make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.forward(ch)
+	s.routes[token] = r
+	r.stop = func() {
+		s.mu.Lock()
+		delete(s.routes, token)
+		s.mu.Unlock()
+		close(r.done)
+		r.wg.Wait()
+	}
+	stop := context.AfterFunc(ctx, r.close)
+	return s.prefix + "." + token, unsubscriberFunc(func() error {
+		stop()
+		r.close()
+		return nil
+	}), nil
+}
+
+// deliver never blocks the shared subscription: like a channel subscription,
+// a reply that does not fit into the route buffer is dropped.
+func (s *sharedInbox) deliver(_ context.Context, msg peanats.Msg) error {
+	i := strings.LastIndexByte(msg.Subject(), '.')
+	s.mu.Lock()
+	r, ok := s.routes[msg.Subject()[i+1:]]
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if r.beat != nil && IsHeartbeat(msg) {
+		select {
+		case r.beat <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+	select {
+	case r.in <- msg:
+	default:
+	}
+	return nil
+}
+
+type inboxRoute struct {
+	in   chan peanats.Msg
+	beat chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+	stop func()
+}
+
+func (r *inboxRoute) forward(out chan peanats.Msg) {
+	defer r.wg.Done()
+	for {
+		select {
+		case <-r.done:
+			return
+		case msg := <-r.in:
+			select {
+			case <-r.done:
+				return
+			case out <- msg:
+			}
+		}
+	}
+}
+
+func (r *inboxRoute) close() {
+	r.once.Do(r.stop)
+}
+
+type unsubscriberFunc func() error
+
+func (f unsubscriberFunc) Unsubscribe() error {
+	return f()
+}
@@ -0,0 +1,101 @@
+package requester
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/internal/xtestutil"
+	"github.com/mikluko/peanats/transport"
+)
+
+func TestRequester_SharedInbox(t *testing.T) {
+	type request struct {
+		N int `json:"n"`
+	}
+	type response struct {
+		Seq int `json:"seq"`
+	}
+
+	ns := xtestutil.Server(t)
+	nc := xtestutil.Conn(t, ns)
+
+	argh := peanats.ArgHandlerFunc[request](func(ctx context.Context, arg peanats.Arg[request]) error {
+		for i := 0; i < arg.Value().N; i++ {
+			if err := arg.(peanats.Respondable).Respond(ctx, &response{Seq: i}); err != nil {
+				return err
+			}
+		}
+		return arg.(peanats.Respondable).Respond(ctx, nil)
+	})
+	sub, err := nc.SubscribeHandler(t.Context(), "stream.query", peanats.MsgHandlerFromArgHandler(argh),
+		transport.SubscribeHandlerDispatcher(peanats.NewDispatcher()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sub.Unsubscribe() })
+
+	c := New[request, response](nc, RequesterSharedInbox())
+	shared := c.(*clientImpl[request, response]).shared
+
+	t.Run("concurrent streams", func(t *testing.T) {
+		// the first call creates the shared subscription
+		rcv, err := c.ResponseReceiver(t.Context(), "stream.query", &request{N: 0})
+		require.NoError(t, err)
+		_, err = rcv.Next(t.Context())
+		require.ErrorIs(t, err, ErrOver)
+		require.NoError(t, rcv.Stop())
+		before := ns.NumSubscriptions()
+
+		var wg sync.WaitGroup
+		for i := 1; i <= 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+				defer cancel()
+				rcv, err := c.ResponseReceiver(ctx, "stream.query", &request{N: i}, ResponseReceiverBuffer(uint(i)))
+				if !assert.NoError(t, err) {
+					return
+				}
+				defer func() { _ = rcv.Stop() }()
+				for j := 0; j < i; j++ {
+					rs, err := rcv.Next(ctx)
+					if !assert.NoError(t, err) {
+						return
+					}
+					assert.Equal(t, j, rs.Value().Seq)
+				}
+				_, err = rcv.Next(ctx)
+				assert.True(t, errors.Is(err, ErrOver), err)
+			}()
+		}
+		wg.Wait()
+
+		// no subscription is added per call
+		assert.Equal(t, before, ns.NumSubscriptions())
+		shared.mu.Lock()
+		assert.Empty(t, shared.routes)
+		shared.mu.Unlock()
+	})
+	t.Run("gather", func(t *testing.T) {
+		g, err := c.Gather(t.Context(), "stream.query", &request{N: 1}, GatherMax(1))
+		require.NoError(t, err)
+		require.Len(t, g.Responses, 1)
+	})
+	t.Run("cleanup on context cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		_, err := c.ResponseReceiver(ctx, "stream.query", &request{N: 0})
+		require.NoError(t, err)
+		cancel()
+		assert.Eventually(t, func() bool {
+			shared.mu.Lock()
+			defer shared.mu.Unlock()
+			return len(shared.routes) == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+}
@@ -6,23 +6,32 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/nats-io/nats.go"
 
 	"github.com/mikluko/peanats"
 	"github.com/mikluko/peanats/codec"
+	"github.com/mikluko/peanats/internal/xstream"
 	"github.com/mikluko/peanats/transport"
 )
 
 type RequestOption func(*requestParams)
 
 type requestParams struct {
-	header peanats.Header
+	header         peanats.Header
+	retries        uint64
+	delay          peanats.DelayPolicy
+	retryOn        []error
+	attemptTimeout time.Duration
+	hedge          *hedgeParams
 }
 
 func makeRequestParams(opts ...RequestOption) requestParams {
 	p := requestParams{
-		header: peanats.Header{codec.HeaderContentType: []string{codec.JSON.String()}},
+		header:  peanats.Header{codec.HeaderContentType: []string{codec.JSON.String()}},
+		retryOn: DefaultRetryOn,
 	}
 	for _, opt := range opts {
 		opt(&p)
@@ -58,23 +67,36 @@ func RequestContentEncoding(e codec.ContentEncoding) RequestOption {
 type Requester[RQ, RS any] interface {
 	Request(context.Context, string, *RQ, ...RequestOption) (Response[RS], error)
 	ResponseReceiver(context.Context, string, *RQ, ...ResponseReceiverOption) (ResponseReceiver[RS], error)
+	Gather(context.Context, string, *RQ, ...GatherOption) (*Gathered[RS], error)
+	OpenSession(context.Context, string, ...SessionOption) (Session[RQ, RS], error)
 }
 
-func New[RQ, RS any](nc transport.Conn) Requester[RQ, RS] {
-	return &clientImpl[RQ, RS]{nc}
+func New[RQ, RS any](nc transport.Conn, opts ...RequesterOption) Requester[RQ, RS] {
+	p := requesterParams{}
+	for _, o := range opts {
+		o(&p)
+	}
+	c := &clientImpl[RQ, RS]{nc: nc, latency: &latencies{}}
+	if p.shared {
+		c.shared = newSharedInbox(nc)
+	}
+	return c
 }
 
 type clientImpl[RQ, RS any] struct {
-	nc transport.Conn
+	nc      transport.Conn
+	latency *latencies
+	shared  *sharedInbox
 }
 
 func (c *clientImpl[RQ, RS]) Request(ctx context.Context, subj string, rq *RQ, opts ...RequestOption) (Response[RS], error) {
 	p := makeRequestParams(opts...)
+	p.idempotent()
 	data, err := codec.MarshalHeader(rq, p.header)
 	if err != nil {
 		return nil, err
 	}
-	msg, err := c.nc.Request(ctx, requestMessageImpl{subj: subj, header: p.header, data: data})
+	msg, err := c.send(ctx, requestMessageImpl{subj: subj, header: p.header, data: data}, &p)
 	if err != nil {
 		return nil, err
 	}
@@ -88,34 +110,83 @@ func (c *clientImpl[RQ, RS]) Request(ctx context.Context, subj string, rq *RQ, o
 
 func (c *clientImpl[RQ, RS]) ResponseReceiver(ctx context.Context, subj string, rq *RQ, opts ...ResponseReceiverOption) (ResponseReceiver[RS], error) {
 	rcvParams := responseReceiverParams{
-		buffer:    DefaultBuffer,
-		skipper:   DefaultSkipper,
-		proceeder: DefaultProceeder,
+		buffer:         DefaultBuffer,
+		skipper:        DefaultSkipper,
+		proceeder:      DefaultProceeder,
+		reorder:        DefaultReorderWindow,
+		reorderTimeout: DefaultReorderTimeout,
 	}
 	for _, opt := range opts {
 		opt(&rcvParams)
 	}
-	reqParams := makeRequestParams(rcvParams.rqOpts...)
-	data, err := codec.MarshalHeader(rq, reqParams.header)
+	ctl := newStreamControl(c.nc, rcvParams.window, rcvParams.flowTimeout)
+	if rcvParams.window > 0 {
+		// room for the whole window and the terminating message; heartbeats
+		// are kept out of the buffer
+		rcvParams.buffer = max(rcvParams.buffer, rcvParams.window+1)
+	}
+	rcvParams.rqOpts = append(rcvParams.rqOpts, RequestHeader(ctl.header()))
+	var beat chan struct{}
+	if rcvParams.idleTimeout > 0 {
+		beat = make(chan struct{}, 1)
+		rcvParams.rqOpts = append(rcvParams.rqOpts, RequestHeader(heartbeatHeader(rcvParams.idleTimeout)))
+	}
+	buf, sub, err := c.inbox(ctx, subj, rq, rcvParams.buffer, beat, rcvParams.rqOpts...)
 	if err != nil {
 		return nil, err
 	}
-	msg := requestMessageImpl{subj: subj, repl: nats.NewInbox(), header: reqParams.header, data: data}
+
+	r := &responseReceiverImpl[RS]{
+		buf:  buf,
+		sub:  sub,
+		skp:  rcvParams.skipper,
+		pdr:  rcvParams.proceeder,
+		ctl:  ctl,
+		idle: rcvParams.idleTimeout,
+		beat: beat,
+		seq:  xstream.NewSequencer(rcvParams.reorder, rcvParams.reorderTimeout),
+	}
+	r.release = context.AfterFunc(ctx, func() { _ = r.cancel() })
+	return r, nil
+}
+
+// inbox publishes the request with a fresh inbox as its reply subject and
+// returns the channel receiving whatever is sent to that inbox. With a
+// non-nil beat, heartbeats are signaled on beat instead of taking up room in
+// the channel.
+func (c *clientImpl[RQ, RS]) inbox(ctx context.Context, subj string, rq *RQ, buffer uint, beat chan struct{}, opts ...RequestOption) (chan peanats.Msg, transport.Unsubscriber, error) {
+	reqParams := makeRequestParams(opts...)
+	data, err := codec.MarshalHeader(rq, reqParams.header)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg := requestMessageImpl{subj: subj, header: reqParams.header, data: data}
 
 	// message is ready, prepare response sequence subscription
-	buf := make(chan peanats.Msg, rcvParams.buffer)
-	sub, err := c.nc.SubscribeChan(ctx, msg.Reply(), buf)
+	buf := make(chan peanats.Msg, buffer)
+	var sub transport.Unsubscriber
+	switch {
+	case c.shared != nil:
+		msg.repl, sub, err = c.shared.open(ctx, buf, beat)
+	case beat != nil:
+		msg.repl = nats.NewInbox()
+		sub, err = c.nc.SubscribeHandler(ctx, msg.Reply(), heartbeatFilter(buf, beat),
+			transport.SubscribeHandlerDispatcher(xstream.InlineDispatcher{}))
+	default:
+		msg.repl = nats.NewInbox()
+		sub, err = c.nc.SubscribeChan(ctx, msg.Reply(), buf)
+	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// send the request
 	err = c.nc.Publish(ctx, msg)
 	if err != nil {
-		return nil, err
+		_ = sub.Unsubscribe()
+		return nil, nil, err
 	}
-
-	return &responseReceiverImpl[RS]{buf: buf, sub: sub, skp: rcvParams.skipper, pdr: rcvParams.proceeder}, nil
+	return buf, sub, nil
 }
 
 type requestMessageImpl struct {
@@ -167,10 +238,15 @@ type ResponseReceiver[T any] interface {
 type ResponseReceiverOption func(*responseReceiverParams)
 
 type responseReceiverParams struct {
-	buffer    uint
-	skipper   Skipper
-	proceeder Proceeder
-	rqOpts    []RequestOption
+	buffer         uint
+	skipper        Skipper
+	proceeder      Proceeder
+	rqOpts         []RequestOption
+	window         uint
+	flowTimeout    time.Duration
+	idleTimeout    time.Duration
+	reorder        uint
+	reorderTimeout time.Duration
 }
 
 const DefaultBuffer = 0
@@ -203,14 +279,14 @@ type Proceeder interface {
 type proceederImpl struct{}
 
 func (p *proceederImpl) Proceed(_ context.Context, msg peanats.Msg) (bool, error) {
-	return len(msg.Data()) != 0, nil
+	return len(msg.Data()) != 0 || IsHeartbeat(msg), nil
 }
 
 var (
 	// DefaultSkipper skips empty messages.
 	DefaultSkipper Skipper = &skipperImpl{}
 
-	// DefaultProceeder proceeds only on non-empty messages.
+	// DefaultProceeder proceeds only on non-empty messages and heartbeats.
 	DefaultProceeder Proceeder = &proceederImpl{}
 
 	// The combination of default Skipper and Proceeder effectively create logic
@@ -249,6 +325,12 @@ type responseReceiverImpl[T any] struct {
 	pdr     Proceeder
 	proceed bool
 	once    sync.Once
+	ctl     *streamControl
+	idle    time.Duration
+	beat    chan struct{}
+	seq     *xstream.Sequencer
+	over    atomic.Bool
+	release func() bool
 }
 
 var (
@@ -263,36 +345,131 @@ func (r *responseReceiverImpl[T]) Next(ctx context.Context) (_ Response[T], err
 	if !r.proceed {
 		return nil, ErrOver
 	}
+	r.msg, err = r.receive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.proceed, err = r.pdr.Proceed(ctx, r.msg)
+	if err != nil {
+		return nil, err
+	}
+	if !r.proceed {
+		r.over.Store(true)
+	} else if r.ctl != nil {
+		if err := r.ctl.consume(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if skip, err := r.skp.Skip(ctx, r.msg); err != nil {
+		return nil, err
+	} else if !skip {
+		x := new(T)
+		err := codec.UnmarshalHeader(r.msg.Data(), x, r.msg.Header())
+		if err != nil {
+			return nil, err
+		}
+		return &responseImpl[T]{header: r.msg.Header(), payload: x}, nil
+	} else { // skip == true
+		if r.proceed {
+			return nil, ErrSkip
+		} else {
+			return nil, ErrOver
+		}
+	}
+}
+
+// receive returns the next message in sequence order. Heartbeats and
+// duplicates are consumed silently; like any other message they restart the
+// idle and flow control timers.
+func (r *responseReceiverImpl[T]) receive(ctx context.Context) (peanats.Msg, error) {
+	if msg, ok := r.seq.Pop(); ok {
+		return msg, nil
+	}
+	var (
+		stall, timeout <-chan time.Time
+		idle, flow     *time.Timer
+	)
+	if r.ctl != nil && r.ctl.window > 0 && r.ctl.timeout > 0 {
+		flow = time.NewTimer(r.ctl.timeout)
+		defer flow.Stop()
+		timeout = flow.C
+	}
+	if r.idle > 0 {
+		idle = time.NewTimer(r.idle)
+		defer idle.Stop()
+		stall = idle.C
+	}
+	alive := func() {
+		if idle != nil {
+			idle.Reset(r.idle)
+		}
+		if flow != nil {
+			flow.Reset(r.ctl.timeout)
+		}
+	}
 	for {
+		gap := r.seq.Expired()
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
-		case r.msg = <-r.buf:
-			r.proceed, err = r.pdr.Proceed(ctx, r.msg)
+		case <-timeout:
+			return nil, ErrFlowControlTimeout
+		case <-stall:
+			return nil, ErrStalled
+		case <-gap:
+			return nil, r.seq.Gap()
+		case <-r.beat:
+			alive()
+		case msg := <-r.buf:
+			alive()
+			if IsHeartbeat(msg) {
+				continue
+			}
+			msg, err := r.seq.Push(msg)
 			if err != nil {
 				return nil, err
 			}
-			if skip, err := r.skp.Skip(ctx, r.msg); err != nil {
-				return nil, err
-			} else if !skip {
-				x := new(T)
-				err := codec.UnmarshalHeader(r.msg.Data(), x, r.msg.Header())
-				if err != nil {
-					return nil, err
-				}
-				return &responseImpl[T]{header: r.msg.Header(), payload: x}, nil
-			} else { // skip == true
-				if r.proceed {
-					return nil, ErrSkip
-				} else {
-					return nil, ErrOver
-				}
+			if msg != nil {
+				return msg, nil
 			}
 		}
 	}
 }
 
+// heartbeatFilter delivers messages to buf, dropping them when it is full
+// the way a channel subscription does, and signals heartbeats on beat.
+func heartbeatFilter(buf chan peanats.Msg, beat chan struct{}) peanats.MsgHandler {
+	return peanats.MsgHandlerFunc(func(_ context.Context, msg peanats.Msg) error {
+		if IsHeartbeat(msg) {
+			select {
+			case beat <- struct{}{}:
+			default:
+			}
+			return nil
+		}
+		select {
+		case buf <- msg:
+		default:
+		}
+		return nil
+	})
+}
+
+// Stop unsubscribes from the reply subject and, unless the sequence is over,
+// tells the responder to stop producing it. The buffer is left open: the
+// subscription may still be delivering into it.
 func (r *responseReceiverImpl[T]) Stop() error {
-	close(r.buf)
-	return r.sub.Unsubscribe()
+	if r.release != nil {
+		r.release()
+	}
+	return errors.Join(r.sub.Unsubscribe(), r.cancel())
+}
+
+// cancel signals the responder that the requester is gone, which is
+// pointless once the sequence is over.
+func (r *responseReceiverImpl[T]) cancel() error {
+	if r.ctl == nil || r.over.Load() {
+		return nil
+	}
+	return r.ctl.cancel()
 }
@@ -0,0 +1,235 @@
+package requester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+
+	"github.com/mikluko/peanats"
+)
+
+// HeaderRequestID carries the request ID. Retried and hedged copies of a
+// request share the ID, so that responders can deduplicate them.
+const HeaderRequestID = "Peanats-Request-Id"
+
+// ErrAttemptTimeout is returned when a single attempt exceeds the timeout set
+// with RequestAttemptTimeout while the request context is still alive.
+var ErrAttemptTimeout = errors.New("request attempt timed out")
+
+// DefaultRetryOn lists the errors retried unless RequestRetryOn is given.
+var DefaultRetryOn = []error{nats.ErrNoResponders, nats.ErrTimeout, ErrAttemptTimeout}
+
+// RequestID sets the request ID header. Without it an ID is generated for
+// requests that may be sent more than once, i.e. with retry or hedging.
+func RequestID(id string) RequestOption {
+	return func(p *requestParams) {
+		p.header.Set(HeaderRequestID, id)
+	}
+}
+
+// RequestRetry retries a failed request up to n more times, pausing as
+// defined by the policy. A nil policy retries immediately. Retries, attempt
+// timeouts and hedging apply to Request only.
+func RequestRetry(n uint64, policy peanats.DelayPolicy) RequestOption {
+	return func(p *requestParams) {
+		p.retries = n
+		p.delay = policy
+	}
+}
+
+// RequestRetryOn replaces the set of errors that are retried. Errors are
+// matched with errors.Is.
+func RequestRetryOn(errs ...error) RequestOption {
+	return func(p *requestParams) {
+		p.retryOn = errs
+	}
+}
+
+// RequestAttemptTimeout bounds every single attempt, so that a request stuck
+// on an unresponsive replica can be retried within the overall deadline.
+func RequestAttemptTimeout(d time.Duration) RequestOption {
+	return func(p *requestParams) {
+		p.attemptTimeout = d
+	}
+}
+
+// RequestHedge sends a second copy of the request when no reply arrived
+// within the given percentile (e.g. 95) of recent latencies, and takes
+// whichever reply comes first. Latencies are tracked per subject. Until enough
+// latencies were observed for the subject, fallback is used as the threshold.
+func RequestHedge(percentile float64, fallback time.Duration) RequestOption {
+	return func(p *requestParams) {
+		p.hedge = &hedgeParams{percentile: percentile, fallback: fallback}
+	}
+}
+
+type hedgeParams struct {
+	percentile float64
+	fallback   time.Duration
+}
+
+func (p *requestParams) retryable(err error) bool {
+	for _, target := range p.retryOn {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// idempotent marks requests that may reach responders more than once.
+func (p *requestParams) idempotent() {
+	if (p.retries > 0 || p.hedge != nil) && p.header.Get(HeaderRequestID) == "" {
+		p.header.Set(HeaderRequestID, nuid.Next())
+	}
+}
+
+func (c *clientImpl[RQ, RS]) send(ctx context.Context, msg peanats.Msg, p *requestParams) (peanats.Msg, error) {
+	for attempt := uint64(1); ; attempt++ {
+		res, err := c.attempt(ctx, msg, p)
+		if err == nil || attempt > p.retries || !p.retryable(err) || ctx.Err() != nil {
+			return res, err
+		}
+		var delay time.Duration
+		if p.delay != nil {
+			delay = p.delay.Delay(attempt)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (c *clientImpl[RQ, RS]) attempt(ctx context.Context, msg peanats.Msg, p *requestParams) (peanats.Msg, error) {
+	actx := ctx
+	if p.attemptTimeout > 0 {
+		var cancel context.CancelFunc
+		actx, cancel = context.WithTimeout(ctx, p.attemptTimeout)
+		defer cancel()
+	}
+	var (
+		res peanats.Msg
+		err error
+	)
+	if p.hedge == nil {
+		res, err = c.nc.Request(actx, msg)
+	} else {
+		res, err = c.hedged(actx, msg, p.hedge)
+	}
+	if err != nil && ctx.Err() == nil && actx.Err() != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAttemptTimeout, err)
+	}
+	return res, err
+}
+
+type hedgeResult struct {
+	msg peanats.Msg
+	err error
+}
+
+func (c *clientImpl[RQ, RS]) hedged(ctx context.Context, msg peanats.Msg, p *hedgeParams) (peanats.Msg, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	start := time.Now()
+	results := make(chan hedgeResult, 2)
+	send := func() {
+		res, err := c.nc.Request(ctx, msg)
+		results <- hedgeResult{res, err}
+	}
+	go send()
+
+	window := c.latency.window(msg.Subject())
+	timer := time.NewTimer(window.percentile(p.percentile, p.fallback))
+	defer timer.Stop()
+	pending := 1
+	for {
+		select {
+		case <-timer.C:
+			pending++
+			go send()
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				window.add(time.Since(start))
+				return r.msg, nil
+			}
+			if pending == 0 {
+				return nil, r.err
+			}
+		}
+	}
+}
+
+// latencies keeps a latencyWindow per subject, so that a slow subject does
+// not shift the hedging threshold of the others. Up to latencySubjects
+// subjects are tracked, past which an arbitrary one is forgotten.
+type latencies struct {
+	mu      sync.Mutex
+	windows map[string]*latencyWindow
+}
+
+const latencySubjects = 1024
+
+func (l *latencies) window(subj string) *latencyWindow {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if w, ok := l.windows[subj]; ok {
+		return w
+	}
+	if l.windows == nil {
+		l.windows = make(map[string]*latencyWindow)
+	}
+	if len(l.windows) >= latencySubjects {
+		for k := range l.windows {
+			delete(l.windows, k)
+			break
+		}
+	}
+	w := &latencyWindow{}
+	l.windows[subj] = w
+	return w
+}
+
+// latencyWindow keeps the most recent request latencies.
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+const (
+	latencyWindowSize = 128
+	latencyMinSamples = 16
+)
+
+func (w *latencyWindow) add(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencyWindowSize
+}
+
+func (w *latencyWindow) percentile(q float64, fallback time.Duration) time.Duration {
+	w.mu.Lock()
+	if len(w.samples) < latencyMinSamples {
+		w.mu.Unlock()
+		return fallback
+	}
+	s := slices.Clone(w.samples)
+	w.mu.Unlock()
+	slices.Sort(s)
+	i := int(q / 100 * float64(len(s)))
+	return s[min(max(i, 0), len(s)-1)]
+}
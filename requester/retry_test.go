@@ -0,0 +1,181 @@
+package requester
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/internal/xmock/peanatsmock"
+	"github.com/mikluko/peanats/internal/xmock/transportmock"
+)
+
+type constantDelay time.Duration
+
+func (d constantDelay) Delay(uint64) time.Duration {
+	return time.Duration(d)
+}
+
+func TestRequester_Retry(t *testing.T) {
+	type request struct {
+		Foo string `json:"foo"`
+	}
+	type response struct {
+		Bar string `json:"bar"`
+	}
+	okMsg := func(t *testing.T) peanats.Msg {
+		msg := peanatsmock.NewMsg(t)
+		msg.EXPECT().Data().Return([]byte(`{"bar": "a dog"}`))
+		msg.EXPECT().Header().Return(peanats.Header{})
+		return msg
+	}
+
+	t.Run("retry until success", func(t *testing.T) {
+		var ids []string
+		nc := transportmock.NewConn(t)
+		nc.EXPECT().Request(mock.Anything, mock.Anything).
+			Run(func(_ context.Context, m peanats.Msg) { ids = append(ids, m.Header().Get(HeaderRequestID)) }).
+			Return(nil, nats.ErrNoResponders).Twice()
+		nc.EXPECT().Request(mock.Anything, mock.Anything).
+			Run(func(_ context.Context, m peanats.Msg) { ids = append(ids, m.Header().Get(HeaderRequestID)) }).
+			Return(okMsg(t), nil).Once()
+
+		c := New[request, response](nc)
+		rs, err := c.Request(t.Context(), "parson.had", &request{Foo: "a dog"},
+			RequestRetry(3, constantDelay(time.Millisecond)))
+		require.NoError(t, err)
+		assert.Equal(t, &response{Bar: "a dog"}, rs.Value())
+
+		require.Len(t, ids, 3)
+		assert.NotEmpty(t, ids[0])
+		assert.Equal(t, ids[0], ids[1])
+		assert.Equal(t, ids[0], ids[2])
+	})
+	t.Run("attempts exhausted", func(t *testing.T) {
+		nc := transportmock.NewConn(t)
+		nc.EXPECT().Request(mock.Anything, mock.Anything).Return(nil, nats.ErrNoResponders).Times(3)
+
+		c := New[request, response](nc)
+		_, err := c.Request(t.Context(), "parson.had", &request{}, RequestRetry(2, nil))
+		require.ErrorIs(t, err, nats.ErrNoResponders)
+	})
+	t.Run("not retryable", func(t *testing.T) {
+		testErr := errors.New("parson had a dog")
+		nc := transportmock.NewConn(t)
+		nc.EXPECT().Request(mock.Anything, mock.Anything).Return(nil, testErr).Once()
+
+		c := New[request, response](nc)
+		_, err := c.Request(t.Context(), "parson.had", &request{}, RequestRetry(2, nil))
+		require.ErrorIs(t, err, testErr)
+	})
+	t.Run("retry on", func(t *testing.T) {
+		testErr := errors.New("parson had a dog")
+		nc := transportmock.NewConn(t)
+		nc.EXPECT().Request(mock.Anything, mock.Anything).Return(nil, testErr).Once()
+		nc.EXPECT().Request(mock.Anything, mock.Anything).Return(nil, nats.ErrNoResponders).Once()
+
+		c := New[request, response](nc)
+		_, err := c.Request(t.Context(), "parson.had", &request{},
+			RequestRetry(2, nil), RequestRetryOn(testErr))
+		require.ErrorIs(t, err, nats.ErrNoResponders)
+	})
+	t.Run("attempt timeout", func(t *testing.T) {
+		nc := transportmock.NewConn(t)
+		nc.EXPECT().Request(mock.Anything, mock.Anything).
+			RunAndReturn(func(ctx context.Context, _ peanats.Msg) (peanats.Msg, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}).Once()
+		nc.EXPECT().Request(mock.Anything, mock.Anything).Return(okMsg(t), nil).Once()
+
+		c := New[request, response](nc)
+		_, err := c.Request(t.Context(), "parson.had", &request{},
+			RequestRetry(1, nil), RequestAttemptTimeout(20*time.Millisecond))
+		require.NoError(t, err)
+	})
+	t.Run("explicit id", func(t *testing.T) {
+		nc := transportmock.NewConn(t)
+		nc.EXPECT().Request(mock.Anything, mock.Anything).
+			Run(func(_ context.Context, m peanats.Msg) {
+				assert.Equal(t, "order-1", m.Header().Get(HeaderRequestID))
+			}).
+			Return(okMsg(t), nil).Once()
+
+		c := New[request, response](nc)
+		_, err := c.Request(t.Context(), "parson.had", &request{}, RequestID("order-1"), RequestRetry(1, nil))
+		require.NoError(t, err)
+	})
+}
+
+func TestRequester_Hedge(t *testing.T) {
+	type request struct{}
+	type response struct {
+		Bar string `json:"bar"`
+	}
+	t.Run("second copy wins", func(t *testing.T) {
+		var calls atomic.Int32
+		nc := transportmock.NewConn(t)
+		nc.EXPECT().Request(mock.Anything, mock.Anything).
+			RunAndReturn(func(ctx context.Context, _ peanats.Msg) (peanats.Msg, error) {
+				if calls.Add(1) == 1 {
+					// the first replica hangs
+					<-ctx.Done()
+					return nil, ctx.Err()
+				}
+				return peanats.NewMsg(&nats.Msg{Data: []byte(`{"bar": "hedged"}`)}), nil
+			}).Times(2)
+
+		c := New[request, response](nc)
+		start := time.Now()
+		rs, err := c.Request(t.Context(), "parson.had", &request{}, RequestHedge(95, 20*time.Millisecond))
+		require.NoError(t, err)
+		assert.Equal(t, "hedged", rs.Value().Bar)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+	t.Run("fast reply is not hedged", func(t *testing.T) {
+		nc := transportmock.NewConn(t)
+		nc.EXPECT().Request(mock.Anything, mock.Anything).
+			Return(peanats.NewMsg(&nats.Msg{Data: []byte(`{"bar": "fast"}`)}), nil).Once()
+
+		c := New[request, response](nc)
+		rs, err := c.Request(t.Context(), "parson.had", &request{}, RequestHedge(95, time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, "fast", rs.Value().Bar)
+	})
+}
+
+func TestLatencyWindow(t *testing.T) {
+	w := &latencyWindow{}
+	assert.Equal(t, time.Second, w.percentile(95, time.Second))
+	for i := 1; i <= 200; i++ {
+		w.add(time.Duration(i) * time.Millisecond)
+	}
+	// only the latest 128 samples are kept: 73ms..200ms
+	assert.Equal(t, 73*time.Millisecond, w.percentile(0, time.Second))
+	assert.Equal(t, 200*time.Millisecond, w.percentile(100, time.Second))
+	assert.Equal(t, 194*time.Millisecond, w.percentile(95, time.Second))
+}
+
+func TestLatencies(t *testing.T) {
+	l := &latencies{}
+	for range latencyMinSamples {
+		l.window("slow").add(time.Second)
+	}
+	assert.Equal(t, time.Second, l.window("slow").percentile(95, time.Millisecond))
+	assert.Equal(t, time.Millisecond, l.window("fast").percentile(95, time.Millisecond))
+	assert.Same(t, l.window("slow"), l.window("slow"))
+
+	for i := range latencySubjects {
+		l.window(strconv.Itoa(i))
+	}
+	assert.Len(t, l.windows, latencySubjects)
+}
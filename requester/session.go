@@ -0,0 +1,168 @@
+package requester
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/mikluko/peanats/codec"
+	"github.com/mikluko/peanats/internal/xstream"
+)
+
+// HeaderSession names the private subject a session peer receives on. It is
+// exchanged in the handshake request and its reply.
+const HeaderSession = xstream.HeaderSession
+
+var (
+	// ErrSessionRefused is returned by OpenSession when the handshake reply
+	// does not name a session subject.
+	ErrSessionRefused = errors.New("session refused")
+
+	// ErrCanceled is returned by Recv when the peer tears the session down.
+	ErrCanceled = xstream.ErrCanceled
+
+	// ErrSessionClosed is returned when using a closed session.
+	ErrSessionClosed = xstream.ErrClosed
+)
+
+type SessionOption func(*sessionParams)
+
+type sessionParams struct {
+	xstream.Params
+	rqOpts []RequestOption
+}
+
+// DefaultSessionWindow is the number of messages a session peer may send
+// ahead of Recv unless set with SessionWindow.
+const DefaultSessionWindow = 64
+
+// SessionWindow sets how many messages the responder may send ahead of Recv.
+func SessionWindow(n uint) SessionOption {
+	return func(p *sessionParams) {
+		p.Window = n
+	}
+}
+
+// SessionIdleTimeout tears the session down when Recv waits longer than d
+// for a message. Recv fails with ErrStalled.
+func SessionIdleTimeout(d time.Duration) SessionOption {
+	return func(p *sessionParams) {
+		p.IdleTimeout = d
+	}
+}
+
+// SessionReorderWindow sets how far out of order messages may arrive.
+func SessionReorderWindow(n uint) SessionOption {
+	return func(p *sessionParams) {
+		p.ReorderWindow = n
+	}
+}
+
+// SessionReorderTimeout sets how long messages arriving ahead of a missing
+// one are held back before Recv gives up with ErrSequenceGap.
+func SessionReorderTimeout(d time.Duration) SessionOption {
+	return func(p *sessionParams) {
+		p.ReorderTimeout = d
+	}
+}
+
+// SessionContentType sets the content type of the sent messages.
+func SessionContentType(c codec.ContentType) SessionOption {
+	return func(p *sessionParams) {
+		p.ContentType = c
+	}
+}
+
+// SessionContentEncoding sets the compression algorithm of the sent messages.
+func SessionContentEncoding(e codec.ContentEncoding) SessionOption {
+	return func(p *sessionParams) {
+		p.ContentEncoding = e
+	}
+}
+
+// SessionRequestOptions appends the set of request options for the handshake
+// request.
+func SessionRequestOptions(opts ...RequestOption) SessionOption {
+	return func(p *sessionParams) {
+		p.rqOpts = append(p.rqOpts, opts...)
+	}
+}
+
+// Session is the requester end of a bidirectional stream. Send and CloseSend
+// may be used concurrently with Recv.
+type Session[RQ, RS any] interface {
+	// Send sends the next message to the responder.
+	Send(context.Context, *RQ) error
+	// CloseSend tells the responder that no more messages follow.
+	CloseSend(context.Context) error
+	// Recv returns the next message of the responder, or ErrOver once the
+	// responder closed its side.
+	Recv(context.Context) (Response[RS], error)
+	// Close tears the session down, canceling the responder unless both
+	// sides are over.
+	Close() error
+	// Context is done when the session is torn down.
+	Context() context.Context
+}
+
+// OpenSession performs the handshake with a responder accepting sessions on
+// subj. The session is torn down when ctx is done.
+func (c *clientImpl[RQ, RS]) OpenSession(ctx context.Context, subj string, opts ...SessionOption) (Session[RQ, RS], error) {
+	p := sessionParams{
+		Params: xstream.Params{
+			Window:         DefaultSessionWindow,
+			ReorderWindow:  DefaultReorderWindow,
+			ReorderTimeout: DefaultReorderTimeout,
+			ContentType:    codec.JSON,
+		},
+	}
+	for _, o := range opts {
+		o(&p)
+	}
+	s, err := xstream.Listen(ctx, c.nc, p.Params)
+	if err != nil {
+		return nil, err
+	}
+	rq := makeRequestParams(p.rqOpts...)
+	for k, v := range s.Header() {
+		rq.header[k] = v
+	}
+	res, err := c.nc.Request(ctx, requestMessageImpl{subj: subj, header: rq.header})
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	if res.Header().Get(HeaderSession) == "" {
+		_ = s.Close()
+		return nil, ErrSessionRefused
+	}
+	s.Connect(res.Header())
+	return &sessionImpl[RQ, RS]{s}, nil
+}
+
+type sessionImpl[RQ, RS any] struct {
+	*xstream.Session
+}
+
+func (s *sessionImpl[RQ, RS]) Send(ctx context.Context, v *RQ) error {
+	if v == nil {
+		return xstream.ErrNilValue
+	}
+	return s.Session.Send(ctx, v)
+}
+
+func (s *sessionImpl[RQ, RS]) Recv(ctx context.Context) (Response[RS], error) {
+	msg, err := s.Session.Recv(ctx)
+	if errors.Is(err, io.EOF) {
+		return nil, ErrOver
+	}
+	if err != nil {
+		return nil, err
+	}
+	x := new(RS)
+	if err := codec.UnmarshalHeader(msg.Data(), x, msg.Header()); err != nil {
+		return nil, err
+	}
+	return &responseImpl[RS]{header: msg.Header(), payload: x}, nil
+}
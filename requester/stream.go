@@ -0,0 +1,185 @@
+package requester
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/internal/xstream"
+	"github.com/mikluko/peanats/transport"
+)
+
+// Streaming protocol headers, understood by the responder package.
+const (
+	// HeaderControl names the subject the responder listens on for control
+	// messages sent by the requester during a streaming response.
+	HeaderControl = "Peanats-Control"
+	// HeaderCredit carries the number of messages the responder may send
+	// before it has to wait for more credit.
+	HeaderCredit = xstream.HeaderCredit
+	// HeaderHeartbeat carries the interval at which the requester expects
+	// heartbeats while the responder has nothing else to send.
+	HeaderHeartbeat = "Peanats-Heartbeat"
+	// HeaderSeq carries the 1-based position of a message in the stream,
+	// including the terminating message.
+	HeaderSeq = xstream.HeaderSeq
+	// HeaderStatus marks header-only protocol messages.
+	HeaderStatus = xstream.HeaderStatus
+
+	StatusHeartbeat = xstream.StatusHeartbeat
+	StatusCancel    = xstream.StatusCancel
+)
+
+var (
+	// ErrFlowControlTimeout is returned when the peer of a flow controlled
+	// stream makes no progress within the configured timeout.
+	ErrFlowControlTimeout = errors.New("flow control timeout")
+
+	// ErrStalled is returned by Next when neither a message nor a heartbeat
+	// arrives within the idle timeout.
+	ErrStalled = xstream.ErrStalled
+
+	// ErrSequenceGap is returned by Next when a message of a sequenced stream
+	// was lost or arrived too far out of order to be put back in place.
+	ErrSequenceGap = xstream.ErrSequenceGap
+)
+
+// DefaultReorderWindow is the number of messages a sequenced stream may run
+// ahead of a missing one before Next gives up with ErrSequenceGap.
+const DefaultReorderWindow = 16
+
+// DefaultReorderTimeout is how long messages of a sequenced stream that
+// arrived ahead of a missing one are held back before Next gives up with
+// ErrSequenceGap.
+const DefaultReorderTimeout = time.Second
+
+// ResponseReceiverReorderTimeout sets how long messages arriving ahead of a
+// missing one are held back. Zero waits for the missing message for as long
+// as the stream lasts.
+func ResponseReceiverReorderTimeout(d time.Duration) ResponseReceiverOption {
+	return func(r *responseReceiverParams) {
+		r.reorderTimeout = d
+	}
+}
+
+// ResponseReceiverReorderWindow sets how far out of order messages of a
+// sequenced stream may arrive. Zero accepts messages in order only. Messages
+// without the sequence header are passed through unchecked.
+func ResponseReceiverReorderWindow(n uint) ResponseReceiverOption {
+	return func(r *responseReceiverParams) {
+		r.reorder = n
+	}
+}
+
+// heartbeatsPerIdleTimeout is the number of heartbeats the responder is asked
+// to send within the idle timeout, so that a single lost heartbeat does not
+// fail the stream.
+const heartbeatsPerIdleTimeout = 3
+
+// ResponseReceiverIdleTimeout makes Next fail with ErrStalled when no message
+// arrives within d. The responder is asked to send heartbeats while idle; they
+// are consumed by Next and never returned. Requires a responder.Stream on the
+// other end, otherwise d has to cover the longest pause between replies.
+func ResponseReceiverIdleTimeout(d time.Duration) ResponseReceiverOption {
+	return func(r *responseReceiverParams) {
+		r.idleTimeout = d
+	}
+}
+
+// IsHeartbeat reports whether msg is a heartbeat sent by a streaming responder.
+func IsHeartbeat(msg peanats.Msg) bool {
+	return xstream.IsStatus(msg, StatusHeartbeat)
+}
+
+func heartbeatHeader(idle time.Duration) peanats.Header {
+	h := peanats.Header{}
+	h.Set(HeaderHeartbeat, (idle / heartbeatsPerIdleTimeout).String())
+	return h
+}
+
+// ResponseReceiverFlowControl enables credit based flow control: the
+// responder may send at most window messages ahead of the receiver, so that
+// the receiver buffer never overflows. Next fails with ErrFlowControlTimeout
+// when no message arrives within timeout. Requires a responder.Stream on the
+// other end; other responders ignore the credits.
+func ResponseReceiverFlowControl(window uint, timeout time.Duration) ResponseReceiverOption {
+	return func(r *responseReceiverParams) {
+		r.window = window
+		r.flowTimeout = timeout
+	}
+}
+
+// streamControl is the requester end of the control channel of a stream. It
+// grants credits back to the responder as messages are consumed and tells it
+// when the requester is gone.
+type streamControl struct {
+	nc       transport.Conn
+	subj     string
+	window   uint
+	consumed uint
+	timeout  time.Duration
+	once     sync.Once
+}
+
+func newStreamControl(nc transport.Conn, window uint, timeout time.Duration) *streamControl {
+	return &streamControl{nc: nc, subj: nats.NewInbox(), window: window, timeout: timeout}
+}
+
+func (f *streamControl) header() peanats.Header {
+	h := peanats.Header{}
+	h.Set(HeaderControl, f.subj)
+	if f.window > 0 {
+		h.Set(HeaderCredit, strconv.FormatUint(uint64(f.window), 10))
+	}
+	return h
+}
+
+// consume accounts for a received message and replenishes the credits once
+// half of the window was used up.
+func (f *streamControl) consume(ctx context.Context) error {
+	if f.window == 0 {
+		return nil
+	}
+	f.consumed++
+	if f.consumed < max(f.window/2, 1) {
+		return nil
+	}
+	h := peanats.Header{}
+	h.Set(HeaderCredit, strconv.FormatUint(uint64(f.consumed), 10))
+	f.consumed = 0
+	return f.nc.Publish(ctx, controlMsg{subj: f.subj, header: h})
+}
+
+// cancel tells the responder to stop producing the stream. Only the first
+// call has effect.
+func (f *streamControl) cancel() error {
+	var err error
+	f.once.Do(func() {
+		h := peanats.Header{}
+		h.Set(HeaderStatus, StatusCancel)
+		err = f.nc.Publish(context.Background(), controlMsg{subj: f.subj, header: h})
+	})
+	return err
+}
+
+type controlMsg struct {
+	subj   string
+	header peanats.Header
+}
+
+func (m controlMsg) Subject() string {
+	return m.subj
+}
+
+func (m controlMsg) Header() peanats.Header {
+	return m.header
+}
+
+func (m controlMsg) Data() []byte {
+	return nil
+}
@@ -0,0 +1,109 @@
+package requester
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/internal/xstream"
+)
+
+type seqMsg struct {
+	seq  uint64
+	data string
+}
+
+func (m seqMsg) Subject() string { return "" }
+
+func (m seqMsg) Header() peanats.Header {
+	h := peanats.Header{}
+	if m.seq > 0 {
+		h.Set(HeaderSeq, strconv.FormatUint(m.seq, 10))
+	}
+	return h
+}
+
+func (m seqMsg) Data() []byte { return []byte(m.data) }
+
+type response struct {
+	N int `json:"n"`
+}
+
+func receiverOf(window uint, msgs ...seqMsg) *responseReceiverImpl[response] {
+	buf := make(chan peanats.Msg, len(msgs))
+	for _, m := range msgs {
+		buf <- m
+	}
+	return &responseReceiverImpl[response]{
+		buf: buf,
+		sub: unsubscriberFunc(func() error { return nil }),
+		skp: DefaultSkipper,
+		pdr: DefaultProceeder,
+		seq: xstream.NewSequencer(window, 100*time.Millisecond),
+	}
+}
+
+func collect(t *testing.T, r *responseReceiverImpl[response]) ([]int, error) {
+	var res []int
+	for {
+		rs, err := r.Next(t.Context())
+		if err != nil {
+			return res, err
+		}
+		res = append(res, rs.Value().N)
+	}
+}
+
+func TestResponseReceiver_Sequence(t *testing.T) {
+	t.Run("in order", func(t *testing.T) {
+		r := receiverOf(DefaultReorderWindow,
+			seqMsg{1, `{"n":1}`}, seqMsg{2, `{"n":2}`}, seqMsg{3, ""})
+		res, err := collect(t, r)
+		require.ErrorIs(t, err, ErrOver)
+		assert.Equal(t, []int{1, 2}, res)
+	})
+	t.Run("reordered", func(t *testing.T) {
+		r := receiverOf(DefaultReorderWindow,
+			seqMsg{2, `{"n":2}`}, seqMsg{4, ""}, seqMsg{1, `{"n":1}`}, seqMsg{3, `{"n":3}`})
+		res, err := collect(t, r)
+		require.ErrorIs(t, err, ErrOver)
+		assert.Equal(t, []int{1, 2, 3}, res)
+	})
+	t.Run("duplicates", func(t *testing.T) {
+		r := receiverOf(DefaultReorderWindow,
+			seqMsg{1, `{"n":1}`}, seqMsg{1, `{"n":1}`}, seqMsg{3, ""}, seqMsg{2, `{"n":2}`}, seqMsg{3, ""})
+		res, err := collect(t, r)
+		require.ErrorIs(t, err, ErrOver)
+		assert.Equal(t, []int{1, 2}, res)
+	})
+	t.Run("gap", func(t *testing.T) {
+		r := receiverOf(2,
+			seqMsg{1, `{"n":1}`}, seqMsg{5, `{"n":5}`})
+		res, err := collect(t, r)
+		require.ErrorIs(t, err, ErrSequenceGap)
+		assert.Equal(t, []int{1}, res)
+	})
+	t.Run("lost", func(t *testing.T) {
+		r := receiverOf(DefaultReorderWindow,
+			seqMsg{1, `{"n":1}`}, seqMsg{3, `{"n":3}`}, seqMsg{4, ""})
+		res, err := collect(t, r)
+		require.ErrorIs(t, err, ErrSequenceGap)
+		assert.Equal(t, []int{1}, res)
+	})
+	t.Run("strict order", func(t *testing.T) {
+		r := receiverOf(0, seqMsg{2, `{"n":2}`}, seqMsg{1, `{"n":1}`})
+		_, err := collect(t, r)
+		require.ErrorIs(t, err, ErrSequenceGap)
+	})
+	t.Run("unsequenced", func(t *testing.T) {
+		r := receiverOf(DefaultReorderWindow,
+			seqMsg{0, `{"n":2}`}, seqMsg{0, `{"n":1}`}, seqMsg{0, ""})
+		res, err := collect(t, r)
+		require.ErrorIs(t, err, ErrOver)
+		assert.Equal(t, []int{2, 1}, res)
+	})
+}
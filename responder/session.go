@@ -0,0 +1,137 @@
+package responder
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/codec"
+	"github.com/mikluko/peanats/internal/xstream"
+	"github.com/mikluko/peanats/requester"
+	"github.com/mikluko/peanats/transport"
+)
+
+// ErrNotSession is returned by AcceptSession for a request that is not a
+// session handshake.
+var ErrNotSession = errors.New("message is not a session handshake")
+
+type SessionOption func(*xstream.Params)
+
+// SessionWindow sets how many messages the requester may send ahead of Recv.
+func SessionWindow(n uint) SessionOption {
+	return func(p *xstream.Params) {
+		p.Window = n
+	}
+}
+
+// SessionIdleTimeout tears the session down when Recv waits longer than d
+// for a message. Recv fails with requester.ErrStalled.
+func SessionIdleTimeout(d time.Duration) SessionOption {
+	return func(p *xstream.Params) {
+		p.IdleTimeout = d
+	}
+}
+
+// SessionReorderWindow sets how far out of order messages may arrive.
+func SessionReorderWindow(n uint) SessionOption {
+	return func(p *xstream.Params) {
+		p.ReorderWindow = n
+	}
+}
+
+// SessionReorderTimeout sets how long messages arriving ahead of a missing
+// one are held back before Recv gives up with requester.ErrSequenceGap.
+func SessionReorderTimeout(d time.Duration) SessionOption {
+	return func(p *xstream.Params) {
+		p.ReorderTimeout = d
+	}
+}
+
+// SessionContentType sets the content type of the sent messages.
+func SessionContentType(c codec.ContentType) SessionOption {
+	return func(p *xstream.Params) {
+		p.ContentType = c
+	}
+}
+
+// SessionContentEncoding sets the compression algorithm of the sent messages.
+func SessionContentEncoding(e codec.ContentEncoding) SessionOption {
+	return func(p *xstream.Params) {
+		p.ContentEncoding = e
+	}
+}
+
+// Session is the responder end of a session opened with
+// requester.Requester.OpenSession. Send and CloseSend may be used
+// concurrently with Recv.
+type Session[RQ, RS any] interface {
+	// Recv returns the next message of the requester, or io.EOF once the
+	// requester closed its side.
+	Recv(context.Context) (peanats.Arg[RQ], error)
+	// Send sends the next message to the requester.
+	Send(context.Context, *RS) error
+	// CloseSend tells the requester that no more messages follow.
+	CloseSend(context.Context) error
+	// Close tears the session down, canceling the requester unless both
+	// sides are over.
+	Close() error
+	// Context is done when the session is torn down. context.Cause reports
+	// ErrCanceled when the requester went away.
+	Context() context.Context
+}
+
+// AcceptSession completes the handshake of a session requested with msg. The
+// session is torn down when ctx is done, so ctx must outlive the handler when
+// the session is served asynchronously.
+func AcceptSession[RQ, RS any](ctx context.Context, nc transport.Conn, msg peanats.Msg, opts ...SessionOption) (Session[RQ, RS], error) {
+	r, ok := msg.(peanats.Respondable)
+	if !ok {
+		return nil, ErrNotRespondable
+	}
+	if msg.Header().Get(requester.HeaderSession) == "" {
+		return nil, ErrNotSession
+	}
+	p := xstream.Params{
+		Window:         requester.DefaultSessionWindow,
+		ReorderWindow:  requester.DefaultReorderWindow,
+		ReorderTimeout: requester.DefaultReorderTimeout,
+		ContentType:    codec.JSON,
+	}
+	for _, o := range opts {
+		o(&p)
+	}
+	s, err := xstream.Listen(ctx, nc, p)
+	if err != nil {
+		return nil, err
+	}
+	s.Connect(msg.Header())
+	if err := r.RespondMsg(ctx, streamMsg{header: s.Header()}); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return &sessionImpl[RQ, RS]{s}, nil
+}
+
+type sessionImpl[RQ, RS any] struct {
+	*xstream.Session
+}
+
+func (s *sessionImpl[RQ, RS]) Recv(ctx context.Context) (peanats.Arg[RQ], error) {
+	msg, err := s.Session.Recv(ctx)
+	if err != nil {
+		return nil, err
+	}
+	x := new(RQ)
+	if err := codec.UnmarshalHeader(msg.Data(), x, msg.Header()); err != nil {
+		return nil, err
+	}
+	return peanats.NewArg(msg, x), nil
+}
+
+func (s *sessionImpl[RQ, RS]) Send(ctx context.Context, v *RS) error {
+	if v == nil {
+		return ErrNilValue
+	}
+	return s.Session.Send(ctx, v)
+}
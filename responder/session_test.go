@@ -0,0 +1,182 @@
+package responder_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/internal/xtestutil"
+	"github.com/mikluko/peanats/requester"
+	"github.com/mikluko/peanats/responder"
+	"github.com/mikluko/peanats/transport"
+)
+
+type chunk struct {
+	N int `json:"n"`
+}
+
+type total struct {
+	Sum int `json:"sum"`
+}
+
+func accept(t *testing.T, nc transport.Conn, h func(context.Context, responder.Session[chunk, total]) error, opts ...responder.SessionOption) <-chan error {
+	errs := make(chan error, 1)
+	sub, err := nc.SubscribeHandler(t.Context(), "session.open", peanats.MsgHandlerFunc(func(ctx context.Context, m peanats.Msg) error {
+		s, err := responder.AcceptSession[chunk, total](ctx, nc, m, opts...)
+		if err != nil {
+			errs <- err
+			return nil
+		}
+		defer func() { _ = s.Close() }()
+		errs <- h(ctx, s)
+		return nil
+	}), transport.SubscribeHandlerDispatcher(peanats.NewDispatcher()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sub.Unsubscribe() })
+	return errs
+}
+
+func TestSession(t *testing.T) {
+	t.Run("upload", func(t *testing.T) {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		errs := accept(t, nc, func(ctx context.Context, s responder.Session[chunk, total]) error {
+			var sum int
+			for {
+				arg, err := s.Recv(ctx)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				sum += arg.Value().N
+			}
+			if err := s.Send(ctx, &total{Sum: sum}); err != nil {
+				return err
+			}
+			return s.CloseSend(ctx)
+		}, responder.SessionWindow(4))
+
+		c := requester.New[chunk, total](nc)
+		s, err := c.OpenSession(t.Context(), "session.open")
+		require.NoError(t, err)
+		defer func() { _ = s.Close() }()
+		for i := 1; i <= 1000; i++ {
+			require.NoError(t, s.Send(t.Context(), &chunk{N: i}))
+		}
+		require.NoError(t, s.CloseSend(t.Context()))
+		rs, err := s.Recv(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, 500500, rs.Value().Sum)
+		_, err = s.Recv(t.Context())
+		require.ErrorIs(t, err, requester.ErrOver)
+		require.NoError(t, <-errs)
+	})
+	t.Run("bidirectional", func(t *testing.T) {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		errs := accept(t, nc, func(ctx context.Context, s responder.Session[chunk, total]) error {
+			var sum int
+			for {
+				arg, err := s.Recv(ctx)
+				if errors.Is(err, io.EOF) {
+					return s.CloseSend(ctx)
+				}
+				if err != nil {
+					return err
+				}
+				sum += arg.Value().N
+				if err := s.Send(ctx, &total{Sum: sum}); err != nil {
+					return err
+				}
+			}
+		})
+
+		c := requester.New[chunk, total](nc)
+		s, err := c.OpenSession(t.Context(), "session.open")
+		require.NoError(t, err)
+		defer func() { _ = s.Close() }()
+		sum := 0
+		for i := 1; i <= 10; i++ {
+			require.NoError(t, s.Send(t.Context(), &chunk{N: i}))
+			rs, err := s.Recv(t.Context())
+			require.NoError(t, err)
+			sum += i
+			assert.Equal(t, sum, rs.Value().Sum)
+		}
+		require.NoError(t, s.CloseSend(t.Context()))
+		_, err = s.Recv(t.Context())
+		require.ErrorIs(t, err, requester.ErrOver)
+		require.NoError(t, <-errs)
+	})
+	t.Run("requester close cancels responder", func(t *testing.T) {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		errs := accept(t, nc, func(ctx context.Context, s responder.Session[chunk, total]) error {
+			for {
+				if _, err := s.Recv(ctx); err != nil {
+					return err
+				}
+			}
+		})
+
+		c := requester.New[chunk, total](nc)
+		s, err := c.OpenSession(t.Context(), "session.open")
+		require.NoError(t, err)
+		require.NoError(t, s.Send(t.Context(), &chunk{N: 1}))
+		require.NoError(t, s.Close())
+		require.ErrorIs(t, s.Send(t.Context(), &chunk{N: 2}), requester.ErrSessionClosed)
+
+		select {
+		case err := <-errs:
+			require.ErrorIs(t, err, responder.ErrCanceled)
+		case <-time.After(5 * time.Second):
+			t.Fatal("responder was not canceled")
+		}
+	})
+	t.Run("idle timeout", func(t *testing.T) {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		errs := accept(t, nc, func(ctx context.Context, s responder.Session[chunk, total]) error {
+			_, err := s.Recv(ctx)
+			return err
+		}, responder.SessionIdleTimeout(100*time.Millisecond))
+
+		c := requester.New[chunk, total](nc)
+		s, err := c.OpenSession(t.Context(), "session.open")
+		require.NoError(t, err)
+		defer func() { _ = s.Close() }()
+		require.ErrorIs(t, <-errs, requester.ErrStalled)
+		_, err = s.Recv(t.Context())
+		require.ErrorIs(t, err, requester.ErrCanceled)
+	})
+	t.Run("refused", func(t *testing.T) {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		sub, err := nc.SubscribeHandler(t.Context(), "session.open", peanats.MsgHandlerFunc(func(ctx context.Context, m peanats.Msg) error {
+			return m.(peanats.Respondable).Respond(ctx, &total{})
+		}))
+		require.NoError(t, err)
+		defer func() { _ = sub.Unsubscribe() }()
+
+		c := requester.New[chunk, total](nc)
+		_, err = c.OpenSession(t.Context(), "session.open")
+		require.ErrorIs(t, err, requester.ErrSessionRefused)
+	})
+	t.Run("not a session", func(t *testing.T) {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		errs := accept(t, nc, func(context.Context, responder.Session[chunk, total]) error { return nil })
+		c := requester.New[chunk, total](nc)
+		_, err := c.Request(t.Context(), "session.open", &chunk{}, requester.RequestAttemptTimeout(100*time.Millisecond))
+		require.Error(t, err)
+		require.ErrorIs(t, <-errs, responder.ErrNotSession)
+	})
+}
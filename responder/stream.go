@@ -0,0 +1,288 @@
+// Package responder implements the server side of streaming responses
+// received with requester.ResponseReceiver.
+package responder
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/codec"
+	"github.com/mikluko/peanats/internal/xstream"
+	"github.com/mikluko/peanats/requester"
+	"github.com/mikluko/peanats/transport"
+)
+
+var (
+	ErrNotRespondable = errors.New("message can not be responded to")
+	ErrStreamClosed   = errors.New("stream is closed")
+	ErrNilValue       = xstream.ErrNilValue
+	ErrCanceled       = xstream.ErrCanceled
+
+	// ErrFlowControlTimeout is returned by Send when the requester grants no
+	// credit within the flow control timeout.
+	ErrFlowControlTimeout = requester.ErrFlowControlTimeout
+)
+
+type StreamOption func(*streamParams)
+
+type streamParams struct {
+	contentType     codec.ContentType
+	contentEncoding codec.ContentEncoding
+	flowTimeout     time.Duration
+}
+
+const DefaultFlowControlTimeout = 30 * time.Second
+
+// StreamContentType sets the content type of the streamed messages.
+func StreamContentType(c codec.ContentType) StreamOption {
+	return func(p *streamParams) {
+		p.contentType = c
+	}
+}
+
+// StreamContentEncoding sets the compression algorithm of the streamed messages.
+func StreamContentEncoding(e codec.ContentEncoding) StreamOption {
+	return func(p *streamParams) {
+		p.contentEncoding = e
+	}
+}
+
+// StreamFlowControlTimeout sets how long Send waits for credit from a flow
+// controlled requester.
+func StreamFlowControlTimeout(d time.Duration) StreamOption {
+	return func(p *streamParams) {
+		p.flowTimeout = d
+	}
+}
+
+// Stream sends a sequence of typed replies to a single request.
+type Stream[T any] interface {
+	// Send sends the next message. When the requester asked for flow control,
+	// Send blocks until the requester grants credit.
+	Send(context.Context, *T) error
+	// Close terminates the sequence. It is safe to call more than once. Close
+	// has to be called to release the control subscription and heartbeats.
+	Close(context.Context) error
+	// Context is done when the requester cancels the stream or the stream is
+	// closed. context.Cause reports ErrCanceled or ErrStreamClosed.
+	Context() context.Context
+}
+
+// NewStream creates a stream replying to msg. The connection is used to
+// listen for control messages of the requester. The stream context is derived
+// from ctx; long-running handlers should use it to stop early when the
+// requester goes away.
+func NewStream[T any](ctx context.Context, nc transport.Conn, msg peanats.Msg, opts ...StreamOption) (Stream[T], error) {
+	r, ok := msg.(peanats.Respondable)
+	if !ok {
+		return nil, ErrNotRespondable
+	}
+	p := streamParams{
+		contentType: codec.JSON,
+		flowTimeout: DefaultFlowControlTimeout,
+	}
+	for _, o := range opts {
+		o(&p)
+	}
+	s := &streamImpl[T]{
+		r:      r,
+		params: p,
+		credit: make(chan struct{}, 1),
+	}
+	s.ctx, s.cancel = context.WithCancelCause(ctx)
+	if ctl := msg.Header().Get(requester.HeaderControl); ctl != "" {
+		if n, err := strconv.ParseUint(msg.Header().Get(requester.HeaderCredit), 10, 64); err == nil && n > 0 {
+			s.flow = true
+			s.credits = n
+		}
+		sub, err := nc.SubscribeHandler(s.ctx, ctl, peanats.MsgHandlerFunc(s.control))
+		if err != nil {
+			s.cancel(err)
+			return nil, err
+		}
+		s.sub = sub
+	}
+	if d, err := time.ParseDuration(msg.Header().Get(requester.HeaderHeartbeat)); err == nil && d > 0 {
+		s.wg.Add(1)
+		go s.heartbeat(d)
+	}
+	return s, nil
+}
+
+type streamImpl[T any] struct {
+	r      peanats.Respondable
+	params streamParams
+	sub    transport.Unsubscriber
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	mu      sync.Mutex
+	flow    bool
+	credits uint64
+	credit  chan struct{}
+	closed  bool
+	active  bool
+	seq     uint64
+
+	wg sync.WaitGroup
+}
+
+func (s *streamImpl[T]) Context() context.Context {
+	return s.ctx
+}
+
+// heartbeat keeps the requester from declaring the stream stalled while
+// nothing else is sent. Heartbeats do not consume credit; the requester
+// keeps them out of its receive buffer.
+func (s *streamImpl[T]) heartbeat(d time.Duration) {
+	defer s.wg.Done()
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-t.C:
+			s.mu.Lock()
+			active := s.active
+			s.active = false
+			s.mu.Unlock()
+			if active {
+				continue
+			}
+			h := peanats.Header{}
+			h.Set(requester.HeaderStatus, requester.StatusHeartbeat)
+			_ = s.r.RespondMsg(s.ctx, streamMsg{header: h})
+		}
+	}
+}
+
+func (s *streamImpl[T]) control(_ context.Context, msg peanats.Msg) error {
+	if msg.Header().Get(requester.HeaderStatus) == requester.StatusCancel {
+		s.cancel(ErrCanceled)
+		return nil
+	}
+	if v := msg.Header().Get(requester.HeaderCredit); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil
+		}
+		s.mu.Lock()
+		s.credits += n
+		s.mu.Unlock()
+		select {
+		case s.credit <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// acquire takes a credit, waiting for one if the requester asked for flow
+// control and none is left.
+func (s *streamImpl[T]) acquire(ctx context.Context) error {
+	var timeout <-chan time.Time
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return ErrStreamClosed
+		}
+		if err := context.Cause(s.ctx); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		if !s.flow || s.credits > 0 {
+			if s.flow {
+				s.credits--
+			}
+			s.mu.Unlock()
+			return nil
+		}
+		s.mu.Unlock()
+		if timeout == nil {
+			t := time.NewTimer(s.params.flowTimeout)
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.ctx.Done():
+			return context.Cause(s.ctx)
+		case <-timeout:
+			return ErrFlowControlTimeout
+		case <-s.credit:
+		}
+	}
+}
+
+func (s *streamImpl[T]) Send(ctx context.Context, v *T) error {
+	if v == nil {
+		return ErrNilValue
+	}
+	if err := s.acquire(ctx); err != nil {
+		return err
+	}
+	h := peanats.Header{}
+	h.Set(codec.HeaderContentType, s.params.contentType.String())
+	if s.params.contentEncoding != 0 {
+		codec.SetContentEncoding(h, s.params.contentEncoding)
+	}
+	data, err := codec.MarshalHeader(v, h)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrStreamClosed
+	}
+	s.active = true
+	s.seq++
+	h.Set(requester.HeaderSeq, strconv.FormatUint(s.seq, 10))
+	err = s.r.RespondMsg(ctx, streamMsg{header: h, data: data})
+	s.mu.Unlock()
+	return err
+}
+
+func (s *streamImpl[T]) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.seq++
+	h := peanats.Header{}
+	h.Set(requester.HeaderSeq, strconv.FormatUint(s.seq, 10))
+	s.mu.Unlock()
+	s.cancel(ErrStreamClosed)
+	s.wg.Wait()
+	var err error
+	if s.sub != nil {
+		err = s.sub.Unsubscribe()
+	}
+	return errors.Join(err, s.r.RespondMsg(ctx, streamMsg{header: h}))
+}
+
+type streamMsg struct {
+	header peanats.Header
+	data   []byte
+}
+
+func (m streamMsg) Subject() string {
+	return ""
+}
+
+func (m streamMsg) Header() peanats.Header {
+	return m.header
+}
+
+func (m streamMsg) Data() []byte {
+	return m.data
+}
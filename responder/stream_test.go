@@ -0,0 +1,325 @@
+package responder_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mikluko/peanats"
+	"github.com/mikluko/peanats/internal/xtestutil"
+	"github.com/mikluko/peanats/requester"
+	"github.com/mikluko/peanats/responder"
+	"github.com/mikluko/peanats/transport"
+)
+
+type request struct {
+	N int `json:"n"`
+}
+
+type response struct {
+	Seq int `json:"seq"`
+}
+
+// serve subscribes a streaming handler sending N responses for each request.
+func serve(t *testing.T, nc transport.Conn, sent *atomic.Int64, errs chan<- error, opts ...responder.StreamOption) {
+	h := peanats.MsgHandlerFromArgHandler(peanats.ArgHandlerFunc[request](func(ctx context.Context, arg peanats.Arg[request]) error {
+		s, err := responder.NewStream[response](ctx, nc, arg, opts...)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = s.Close(ctx) }()
+		for i := 0; i < arg.Value().N; i++ {
+			if err := s.Send(ctx, &response{Seq: i}); err != nil {
+				errs <- err
+				return nil
+			}
+			sent.Add(1)
+		}
+		errs <- nil
+		return nil
+	}))
+	sub, err := nc.SubscribeHandler(t.Context(), "stream.query", h,
+		transport.SubscribeHandlerDispatcher(peanats.NewDispatcher()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sub.Unsubscribe() })
+}
+
+func TestStream(t *testing.T) {
+	t.Run("plain", func(t *testing.T) {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		var sent atomic.Int64
+		errs := make(chan error, 1)
+		serve(t, nc, &sent, errs)
+
+		c := requester.New[request, response](nc)
+		rcv, err := c.ResponseReceiver(t.Context(), "stream.query", &request{N: 5}, requester.ResponseReceiverBuffer(10))
+		require.NoError(t, err)
+		defer func() { _ = rcv.Stop() }()
+		for i := 0; i < 5; i++ {
+			rs, err := rcv.Next(t.Context())
+			require.NoError(t, err)
+			assert.Equal(t, i, rs.Value().Seq)
+			assert.Equal(t, strconv.Itoa(i+1), rs.Header().Get(requester.HeaderSeq))
+		}
+		_, err = rcv.Next(t.Context())
+		require.ErrorIs(t, err, requester.ErrOver)
+		require.NoError(t, <-errs)
+	})
+	t.Run("flow control", func(t *testing.T) {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		var sent atomic.Int64
+		errs := make(chan error, 1)
+		serve(t, nc, &sent, errs)
+
+		const window = 8
+		c := requester.New[request, response](nc)
+		rcv, err := c.ResponseReceiver(t.Context(), "stream.query", &request{N: 100},
+			requester.ResponseReceiverFlowControl(window, 5*time.Second))
+		require.NoError(t, err)
+		defer func() { _ = rcv.Stop() }()
+		for i := 0; i < 100; i++ {
+			rs, err := rcv.Next(t.Context())
+			require.NoError(t, err)
+			require.Equal(t, i, rs.Value().Seq)
+			// the responder never runs ahead of the window
+			assert.LessOrEqual(t, sent.Load(), int64(i+1+window))
+			if i%10 == 0 {
+				time.Sleep(5 * time.Millisecond)
+			}
+		}
+		_, err = rcv.Next(t.Context())
+		require.ErrorIs(t, err, requester.ErrOver)
+		require.NoError(t, <-errs)
+	})
+	t.Run("responder times out on stalled requester", func(t *testing.T) {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		var sent atomic.Int64
+		errs := make(chan error, 1)
+		serve(t, nc, &sent, errs, responder.StreamFlowControlTimeout(100*time.Millisecond))
+
+		c := requester.New[request, response](nc)
+		rcv, err := c.ResponseReceiver(t.Context(), "stream.query", &request{N: 100},
+			requester.ResponseReceiverFlowControl(4, time.Second))
+		require.NoError(t, err)
+		defer func() { _ = rcv.Stop() }()
+
+		// never read
+		select {
+		case err := <-errs:
+			require.ErrorIs(t, err, responder.ErrFlowControlTimeout)
+		case <-time.After(5 * time.Second):
+			t.Fatal("responder did not time out")
+		}
+		assert.Equal(t, int64(4), sent.Load())
+	})
+	t.Run("requester times out on stalled responder", func(t *testing.T) {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		h := peanats.MsgHandlerFunc(func(ctx context.Context, m peanats.Msg) error {
+			s, err := responder.NewStream[response](ctx, nc, m)
+			if err != nil {
+				return err
+			}
+			return s.Send(ctx, &response{Seq: 0})
+		})
+		sub, err := nc.SubscribeHandler(t.Context(), "stream.query", h,
+			transport.SubscribeHandlerDispatcher(peanats.NewDispatcher()))
+		require.NoError(t, err)
+		defer func() { _ = sub.Unsubscribe() }()
+
+		c := requester.New[request, response](nc)
+		rcv, err := c.ResponseReceiver(t.Context(), "stream.query", &request{},
+			requester.ResponseReceiverFlowControl(4, 100*time.Millisecond))
+		require.NoError(t, err)
+		defer func() { _ = rcv.Stop() }()
+		_, err = rcv.Next(t.Context())
+		require.NoError(t, err)
+		_, err = rcv.Next(t.Context())
+		require.ErrorIs(t, err, requester.ErrFlowControlTimeout)
+	})
+	t.Run("heartbeats keep idle stream alive", func(t *testing.T) {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		h := peanats.MsgHandlerFunc(func(ctx context.Context, m peanats.Msg) error {
+			s, err := responder.NewStream[response](ctx, nc, m)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = s.Close(ctx) }()
+			if err := s.Send(ctx, &response{Seq: 0}); err != nil {
+				return err
+			}
+			time.Sleep(500 * time.Millisecond)
+			return s.Send(ctx, &response{Seq: 1})
+		})
+		sub, err := nc.SubscribeHandler(t.Context(), "stream.query", h,
+			transport.SubscribeHandlerDispatcher(peanats.NewDispatcher()))
+		require.NoError(t, err)
+		defer func() { _ = sub.Unsubscribe() }()
+
+		c := requester.New[request, response](nc)
+		rcv, err := c.ResponseReceiver(t.Context(), "stream.query", &request{},
+			requester.ResponseReceiverBuffer(10),
+			requester.ResponseReceiverIdleTimeout(150*time.Millisecond))
+		require.NoError(t, err)
+		defer func() { _ = rcv.Stop() }()
+		for i := 0; i < 2; i++ {
+			rs, err := rcv.Next(t.Context())
+			require.NoError(t, err)
+			assert.Equal(t, i, rs.Value().Seq)
+		}
+		_, err = rcv.Next(t.Context())
+		require.ErrorIs(t, err, requester.ErrOver)
+	})
+	t.Run("heartbeats do not take up the window", func(t *testing.T) {
+		for name, opts := range map[string][]requester.RequesterOption{
+			"inbox":        nil,
+			"shared inbox": {requester.RequesterSharedInbox()},
+		} {
+			t.Run(name, func(t *testing.T) {
+				ns := xtestutil.Server(t)
+				nc := xtestutil.Conn(t, ns)
+				const window = 4
+				h := peanats.MsgHandlerFunc(func(ctx context.Context, m peanats.Msg) error {
+					s, err := responder.NewStream[response](ctx, nc, m)
+					if err != nil {
+						return err
+					}
+					defer func() { _ = s.Close(ctx) }()
+					// idle long enough for heartbeats to outnumber the window,
+					// then a burst of a whole window
+					time.Sleep(500 * time.Millisecond)
+					for i := range window {
+						if err := s.Send(ctx, &response{Seq: i}); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				sub, err := nc.SubscribeHandler(t.Context(), "stream.query", h,
+					transport.SubscribeHandlerDispatcher(peanats.NewDispatcher()))
+				require.NoError(t, err)
+				defer func() { _ = sub.Unsubscribe() }()
+
+				c := requester.New[request, response](nc, opts...)
+				rcv, err := c.ResponseReceiver(t.Context(), "stream.query", &request{},
+					requester.ResponseReceiverFlowControl(window, 5*time.Second),
+					requester.ResponseReceiverIdleTimeout(60*time.Millisecond))
+				require.NoError(t, err)
+				defer func() { _ = rcv.Stop() }()
+				// nobody reads while the responder is idle and the burst arrives
+				time.Sleep(time.Second)
+				for i := range window {
+					rs, err := rcv.Next(t.Context())
+					require.NoError(t, err)
+					assert.Equal(t, i, rs.Value().Seq)
+				}
+				_, err = rcv.Next(t.Context())
+				require.ErrorIs(t, err, requester.ErrOver)
+			})
+		}
+	})
+	t.Run("requester detects stalled responder", func(t *testing.T) {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		h := peanats.MsgHandlerFunc(func(ctx context.Context, m peanats.Msg) error {
+			// responds once and dies without closing the sequence
+			return m.(peanats.Respondable).Respond(ctx, &response{Seq: 0})
+		})
+		sub, err := nc.SubscribeHandler(t.Context(), "stream.query", h)
+		require.NoError(t, err)
+		defer func() { _ = sub.Unsubscribe() }()
+
+		c := requester.New[request, response](nc)
+		rcv, err := c.ResponseReceiver(t.Context(), "stream.query", &request{},
+			requester.ResponseReceiverBuffer(10),
+			requester.ResponseReceiverIdleTimeout(100*time.Millisecond))
+		require.NoError(t, err)
+		defer func() { _ = rcv.Stop() }()
+		_, err = rcv.Next(t.Context())
+		require.NoError(t, err)
+		_, err = rcv.Next(t.Context())
+		require.ErrorIs(t, err, requester.ErrStalled)
+	})
+	t.Run("requester stop cancels responder", func(t *testing.T) {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		var sent atomic.Int64
+		errs := make(chan error, 1)
+		serve(t, nc, &sent, errs)
+
+		c := requester.New[request, response](nc)
+		rcv, err := c.ResponseReceiver(t.Context(), "stream.query", &request{N: 1_000_000},
+			requester.ResponseReceiverFlowControl(4, time.Second))
+		require.NoError(t, err)
+		_, err = rcv.Next(t.Context())
+		require.NoError(t, err)
+		require.NoError(t, rcv.Stop())
+
+		select {
+		case err := <-errs:
+			require.ErrorIs(t, err, responder.ErrCanceled)
+		case <-time.After(5 * time.Second):
+			t.Fatal("responder was not canceled")
+		}
+	})
+	t.Run("requester context cancels responder", func(t *testing.T) {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		canceled := make(chan error, 1)
+		h := peanats.MsgHandlerFunc(func(ctx context.Context, m peanats.Msg) error {
+			s, err := responder.NewStream[response](ctx, nc, m)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = s.Close(ctx) }()
+			if err := s.Send(ctx, &response{}); err != nil {
+				return err
+			}
+			<-s.Context().Done()
+			canceled <- context.Cause(s.Context())
+			return nil
+		})
+		sub, err := nc.SubscribeHandler(t.Context(), "stream.query", h,
+			transport.SubscribeHandlerDispatcher(peanats.NewDispatcher()))
+		require.NoError(t, err)
+		defer func() { _ = sub.Unsubscribe() }()
+
+		ctx, cancel := context.WithCancel(t.Context())
+		c := requester.New[request, response](nc)
+		rcv, err := c.ResponseReceiver(ctx, "stream.query", &request{}, requester.ResponseReceiverBuffer(1))
+		require.NoError(t, err)
+		defer func() { _ = rcv.Stop() }()
+		_, err = rcv.Next(ctx)
+		require.NoError(t, err)
+		cancel()
+
+		select {
+		case err := <-canceled:
+			require.ErrorIs(t, err, responder.ErrCanceled)
+		case <-time.After(5 * time.Second):
+			t.Fatal("responder was not canceled")
+		}
+	})
+	t.Run("not respondable", func(t *testing.T) {
+		ns := xtestutil.Server(t)
+		nc := xtestutil.Conn(t, ns)
+		_, err := responder.NewStream[response](t.Context(), nc, plainMsg{})
+		require.True(t, errors.Is(err, responder.ErrNotRespondable))
+	})
+}
+
+type plainMsg struct{}
+
+func (plainMsg) Subject() string        { return "" }
+func (plainMsg) Header() peanats.Header { return nil }
+func (plainMsg) Data() []byte           { return nil }